@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+)
+
+const (
+	stateCookie = "loi_oauth_state"
+	stateTTL    = 10 * time.Minute
+)
+
+// Config of the discord OAuth2 application, the endpoints can point to a
+// local stand-in of discord.
+type Config struct {
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	AuthorizeURL  string
+	TokenURL      string
+	UserURL       string
+	SessionSecret string
+	SessionTTL    time.Duration
+	// AdminToken logs in the admin, the admin login is disabled without it.
+	AdminToken string
+}
+
+// DefaultConfig returns the configuration loaded from the environment.
+func DefaultConfig() Config {
+	c := internal.Config().Auth
+	return Config{
+		ClientID:      c.ClientID,
+		ClientSecret:  c.ClientSecret,
+		RedirectURL:   c.RedirectURL,
+		AuthorizeURL:  c.AuthorizeURL,
+		TokenURL:      c.TokenURL,
+		UserURL:       c.UserURL,
+		SessionSecret: c.SessionSecret,
+		SessionTTL:    time.Duration(c.SessionTTL) * time.Millisecond,
+		AdminToken:    c.AdminToken,
+	}
+}
+
+type Authenticator interface {
+	// Login redirects the browser to the discord authorization page.
+	Login(w http.ResponseWriter, r *http.Request)
+	// Callback completes the login and sets the session cookie.
+	Callback(w http.ResponseWriter, r *http.Request)
+	Logout(w http.ResponseWriter, r *http.Request)
+	// AdminLogin sets the session of the admin when the request has the
+	// configured token, the admin hosts the lobby without discord.
+	AdminLogin(w http.ResponseWriter, r *http.Request)
+	// Me returns the session of the request.
+	Me(w http.ResponseWriter, r *http.Request)
+
+	// Middleware attaches the session of the request to its context when
+	// there is a valid one.
+	Middleware(next http.Handler) http.Handler
+	// Require rejects the requests without a session.
+	Require(next http.Handler) http.Handler
+}
+
+type authenticator struct {
+	cfg    Config
+	secret []byte
+	client *http.Client
+}
+
+var _ Authenticator = (*authenticator)(nil)
+
+func NewAuthenticator(cfg Config) (*authenticator, error) {
+	if cfg.ClientID == "" {
+		slog.Warn("[NewAuthenticator] - no discord client id configured, logins are disabled")
+	}
+	secret := []byte(cfg.SessionSecret)
+	if len(secret) == 0 {
+		slog.Warn("[NewAuthenticator] - no session secret configured, sessions won't survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SessionTTL <= 0 {
+		return nil, errors.New("session ttl must be positive")
+	}
+	return &authenticator{
+		cfg:    cfg,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (a *authenticator) secure() bool {
+	return strings.HasPrefix(a.cfg.RedirectURL, "https://")
+}
+
+// Login implements Authenticator.
+func (a *authenticator) Login(w http.ResponseWriter, r *http.Request) {
+	if a.cfg.ClientID == "" {
+		http.Error(w, "login is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   a.secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, a.authorizeURL(state), http.StatusFound)
+}
+
+// Callback implements Authenticator.
+func (a *authenticator) Callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		slog.Warn(fmt.Sprintf("[Callback] - authorization refused : %s", e))
+		http.Error(w, "authorization refused", http.StatusUnauthorized)
+		return
+	}
+	state, err := r.Cookie(stateCookie)
+	if err != nil || state.Value == "" || state.Value != q.Get("state") {
+		slog.Warn("[Callback] - oauth state does not match")
+		http.Error(w, "invalid oauth state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1})
+
+	token, err := a.exchange(r.Context(), q.Get("code"))
+	if err != nil {
+		slog.Error(fmt.Sprintf("[Callback] - failed to exchange the code : %s", err.Error()))
+		http.Error(w, "failed to login with discord", http.StatusBadGateway)
+		return
+	}
+	u, err := a.user(r.Context(), token)
+	if err != nil {
+		slog.Error(fmt.Sprintf("[Callback] - failed to fetch the discord user : %s", err.Error()))
+		http.Error(w, "failed to login with discord", http.StatusBadGateway)
+		return
+	}
+	name := u.GlobalName
+	if name == "" {
+		name = u.Username
+	}
+	if err := a.setSession(w, &Session{
+		PlayerID: u.ID,
+		Username: name,
+		Avatar:   u.Avatar,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info(fmt.Sprintf("[Callback] - player '%s' logged in", u.ID))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// AdminLogin implements Authenticator.
+func (a *authenticator) AdminLogin(w http.ResponseWriter, r *http.Request) {
+	if a.cfg.AdminToken == "" {
+		http.Error(w, "admin login is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(body.Token), []byte(a.cfg.AdminToken)) != 1 {
+		slog.Warn(fmt.Sprintf("[AdminLogin] - invalid admin token from '%s'", r.RemoteAddr))
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err := a.setSession(w, &Session{
+		PlayerID: AdminPlayerID,
+		Username: "Admin",
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("[AdminLogin] - admin logged in")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setSession signs the session and sets its cookie, the session expires
+// after the configured ttl.
+func (a *authenticator) setSession(w http.ResponseWriter, s *Session) error {
+	expiresAt := time.Now().Add(a.cfg.SessionTTL)
+	s.ExpiresAt = expiresAt.Unix()
+	v, err := encodeSession(s, a.secret)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    v,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   a.secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Logout implements Authenticator.
+func (a *authenticator) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Me implements Authenticator.
+func (a *authenticator) Me(w http.ResponseWriter, r *http.Request) {
+	s, ok := SessionFromContext(r.Context())
+	if !ok {
+		http.Error(w, ErrNoSession.Error(), http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// session returns the valid session of the request.
+func (a *authenticator) session(r *http.Request) (*Session, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	return decodeSession(c.Value, a.secret)
+}
+
+// Middleware implements Authenticator.
+func (a *authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := a.session(r)
+		if err != nil {
+			if !errors.Is(err, ErrNoSession) {
+				slog.Debug(fmt.Sprintf("[Middleware] - ignoring session : %s", err.Error()))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithSession(r.Context(), s)))
+	})
+}
+
+// Require implements Authenticator.
+func (a *authenticator) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := SessionFromContext(r.Context()); !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDiscord stands in for the discord authorization server and api.
+func fakeDiscord(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_id") != "client" || r.Form.Get("client_secret") != "secret" ||
+			r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": "player1", "username": "one", "global_name": "Player One"})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func setupTest(t *testing.T) (*authenticator, *httptest.Server, *http.Client) {
+	discord := fakeDiscord(t)
+	a, err := NewAuthenticator(Config{
+		ClientID:      "client",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://localhost/api/auth/callback",
+		AuthorizeURL:  discord.URL + "/oauth2/authorize",
+		TokenURL:      discord.URL + "/oauth2/token",
+		UserURL:       discord.URL + "/users/@me",
+		SessionSecret: "session-secret",
+		SessionTTL:    time.Hour,
+		AdminToken:    "admin-token",
+	})
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", a.Login)
+	mux.HandleFunc("/callback", a.Callback)
+	mux.HandleFunc("/logout", a.Logout)
+	mux.HandleFunc("/admin", a.AdminLogin)
+	mux.Handle("/me", a.Require(http.HandlerFunc(a.Me)))
+	app := httptest.NewServer(a.Middleware(mux))
+	t.Cleanup(app.Close)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return a, app, client
+}
+
+// login goes through the authorization redirect and returns the state sent
+// to discord.
+func login(t *testing.T, app *httptest.Server, client *http.Client) string {
+	res, err := client.Get(app.URL + "/login")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	loc, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/oauth2/authorize", loc.Path)
+	assert.Equal(t, "client", loc.Query().Get("client_id"))
+	assert.Equal(t, "identify", loc.Query().Get("scope"))
+	return loc.Query().Get("state")
+}
+
+func TestLogin(t *testing.T) {
+	_, app, client := setupTest(t)
+
+	t.Run("Requests without session are rejected", func(t *testing.T) {
+		res, err := client.Get(app.URL + "/me")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Callback with another state is rejected", func(t *testing.T) {
+		login(t, app, client)
+		res, err := client.Get(app.URL + "/callback?code=good-code&state=forged")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("Invalid code is rejected", func(t *testing.T) {
+		state := login(t, app, client)
+		res, err := client.Get(app.URL + "/callback?code=bad-code&state=" + state)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	})
+
+	t.Run("Login sets the session of the discord user", func(t *testing.T) {
+		state := login(t, app, client)
+		res, err := client.Get(app.URL + "/callback?code=good-code&state=" + state)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		assert.Equal(t, "/", res.Header.Get("Location"))
+
+		res, err = client.Get(app.URL + "/me")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var s Session
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&s))
+		assert.Equal(t, "player1", s.PlayerID)
+		assert.Equal(t, "Player One", s.Username)
+	})
+
+	t.Run("Logout clears the session", func(t *testing.T) {
+		res, err := client.Post(app.URL+"/logout", "", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+		res, err = client.Get(app.URL + "/me")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+}
+
+func TestAdminLogin(t *testing.T) {
+	_, app, client := setupTest(t)
+	admin := func(token string) int {
+		res, err := client.Post(app.URL+"/admin", "application/json", strings.NewReader(fmt.Sprintf(`{"token":%q}`, token)))
+		assert.NoError(t, err)
+		return res.StatusCode
+	}
+
+	t.Run("Another token is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, admin("forged"))
+		res, err := client.Get(app.URL + "/me")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("The token sets the session of the admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, admin("admin-token"))
+		res, err := client.Get(app.URL + "/me")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var s Session
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&s))
+		assert.Equal(t, AdminPlayerID, s.PlayerID)
+	})
+}
+
+func TestSessionCookie(t *testing.T) {
+	a, app, client := setupTest(t)
+	u, _ := url.Parse(app.URL)
+	me := func(v string) int {
+		client.Jar.SetCookies(u, []*http.Cookie{{Name: sessionCookie, Value: v}})
+		res, err := client.Get(app.URL + "/me")
+		assert.NoError(t, err)
+		return res.StatusCode
+	}
+
+	valid, err := encodeSession(&Session{PlayerID: "player1", ExpiresAt: time.Now().Add(time.Hour).Unix()}, a.secret)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, me(valid))
+
+	forged, err := encodeSession(&Session{PlayerID: "player1", ExpiresAt: time.Now().Add(time.Hour).Unix()}, []byte("another-secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, me(forged))
+
+	expired, err := encodeSession(&Session{PlayerID: "player1", ExpiresAt: time.Now().Add(-time.Minute).Unix()}, a.secret)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, me(expired))
+}
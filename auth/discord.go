@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// discordUser is the part of the discord user object used for the session.
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Avatar     string `json:"avatar"`
+}
+
+func (a *authenticator) authorizeURL(state string) string {
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.cfg.ClientID},
+		"redirect_uri":  {a.cfg.RedirectURL},
+		"scope":         {"identify"},
+		"state":         {state},
+	}
+	sep := "?"
+	if strings.Contains(a.cfg.AuthorizeURL, "?") {
+		sep = "&"
+	}
+	return a.cfg.AuthorizeURL + sep + q.Encode()
+}
+
+// exchange trades the authorization code for an access token.
+func (a *authenticator) exchange(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.cfg.RedirectURL},
+		"client_id":     {a.cfg.ClientID},
+		"client_secret": {a.cfg.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint answered with status %d", res.StatusCode)
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return "", err
+	}
+	if t.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint did not return an access token")
+	}
+	return t.AccessToken, nil
+}
+
+// user fetches the discord user the access token belongs to.
+func (a *authenticator) user(ctx context.Context, token string) (*discordUser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.UserURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user endpoint answered with status %d", res.StatusCode)
+	}
+	var u discordUser
+	if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+		return nil, err
+	}
+	if u.ID == "" {
+		return nil, fmt.Errorf("user endpoint did not return a user id")
+	}
+	return &u, nil
+}
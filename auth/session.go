@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const sessionCookie = "loi_session"
+
+// AdminPlayerID is the player id of the admin session, discord ids are only
+// digits so it is never a discord user.
+const AdminPlayerID = "admin"
+
+var (
+	ErrNoSession      = errors.New("no session")
+	ErrInvalidSession = errors.New("invalid session")
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// Session is the discord user behind a request, it is stored in a cookie
+// signed by the server.
+type Session struct {
+	PlayerID  string `json:"playerId"`
+	Username  string `json:"username"`
+	Avatar    string `json:"avatar,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (s *Session) expired() bool {
+	return time.Now().Unix() >= s.ExpiresAt
+}
+
+// encodeSession returns the session payload followed by its signature.
+func encodeSession(s *Session, secret []byte) (string, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + sign(payload, secret), nil
+}
+
+func decodeSession(v string, secret []byte) (*Session, error) {
+	payload, sig, ok := strings.Cut(v, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(sign(payload, secret))) {
+		return nil, ErrInvalidSession
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	var s Session
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, ErrInvalidSession
+	}
+	if s.expired() {
+		return nil, ErrSessionExpired
+	}
+	return &s, nil
+}
+
+func sign(payload string, secret []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context carrying the session of the request.
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionFromContext returns the session attached by the authenticator
+// middleware.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	return s, ok && s != nil
+}
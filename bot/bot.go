@@ -0,0 +1,506 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/phturb/bonjack-tools-backend-go/discord"
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/loi"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+)
+
+// commandTimeout is how long a command can run, the interaction is deferred
+// first since discord drops the ones without response after 3 seconds.
+const commandTimeout = 10 * time.Second
+
+// actorSource is the source of the commands sent from discord.
+const actorSource = "discord"
+
+// commands are registered in the configured guild, every lobby command is a
+// subcommand of /loi.
+var commands = []*discordgo.ApplicationCommand{
+	{
+		Name:        "loi",
+		Description: "Control the loi lobby",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "roll",
+				Description: "Roll the roles and champions of the lobby",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "cancel",
+				Description: "Cancel the current game",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Reset the lobby",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "Show the current game",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+				Name:        "pool",
+				Description: "Manage your champion pool",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionSubCommand,
+						Name:        "add",
+						Description: "Add a champion to your pool",
+						Options: []*discordgo.ApplicationCommandOption{
+							championOption,
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionSubCommand,
+						Name:        "remove",
+						Description: "Remove a champion from your pool",
+						Options: []*discordgo.ApplicationCommandOption{
+							championOption,
+						},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+				Name:        "channel",
+				Description: "Choose the voice channel of the lobby",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionSubCommand,
+						Name:        "list",
+						Description: "List the voice channels",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionSubCommand,
+						Name:        "switch",
+						Description: "Track another voice channel",
+						Options: []*discordgo.ApplicationCommandOption{
+							{
+								Type:         discordgo.ApplicationCommandOptionChannel,
+								Name:         "channel",
+								Description:  "Voice channel of the lobby",
+								ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice},
+								Required:     true,
+							},
+						},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "stats",
+				Description: "Show the stats of a player",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "player",
+						Description: "Player to show, yourself by default",
+					},
+				},
+			},
+		},
+	},
+}
+
+var championOption = &discordgo.ApplicationCommandOption{
+	Type:        discordgo.ApplicationCommandOptionString,
+	Name:        "champion",
+	Description: "Name of the champion",
+	Required:    true,
+}
+
+type bot struct {
+	gm loi.GameManager
+	dm discord.DiscordManager
+}
+
+// NewBot registers the slash commands once the discord session is ready and
+// answers them and the buttons of the roll message with the game manager.
+func NewBot(gm loi.GameManager, dm discord.DiscordManager) *bot {
+	b := &bot{
+		gm: gm,
+		dm: dm,
+	}
+	dm.Session().AddHandler(b.onReady)
+	dm.Session().AddHandler(b.onInteractionCreate)
+	return b
+}
+
+func (b *bot) onReady(s *discordgo.Session, e *discordgo.Ready) {
+	guildID := internal.Config().Discord.GuildID
+	if _, err := s.ApplicationCommandBulkOverwrite(e.User.ID, guildID, commands); err != nil {
+		slog.Error(fmt.Sprintf("[onReady] - failed to register the slash commands in guild '%s' : %s", guildID, err.Error()))
+		return
+	}
+	slog.Info(fmt.Sprintf("[onReady] - slash commands registered in guild '%s'", guildID))
+}
+
+// responder is the part of the discord session answering the interactions.
+type responder interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+func (b *bot) onInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	b.interact(s, i)
+}
+
+// interact defers the interaction right away, then runs it and sends the
+// result in place of the deferred response.
+func (b *bot) interact(s responder, i *discordgo.InteractionCreate) {
+	deferred := deferral(i)
+	if deferred == nil {
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, deferred); err != nil {
+		slog.Error(fmt.Sprintf("[interact] - failed to defer the interaction : %s", err.Error()))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	var res *discordgo.InteractionResponse
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		res = b.handleCommand(ctx, i)
+	case discordgo.InteractionMessageComponent:
+		res = b.handleComponent(ctx, i)
+	}
+	if err := sendResult(s, i, deferred, res); err != nil {
+		slog.Error(fmt.Sprintf("[interact] - failed to respond to the interaction : %s", err.Error()))
+	}
+}
+
+// deferral returns the deferred response of the interaction, nil when the
+// interaction is not handled by the bot. The pool commands are only shown to
+// their sender, the clicks don't post a message.
+func deferral(i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		cmd := i.ApplicationCommandData()
+		if cmd.Name != "loi" || len(cmd.Options) == 0 {
+			return nil
+		}
+		res := &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{},
+		}
+		if cmd.Options[0].Name == "pool" {
+			res.Data.Flags = discordgo.MessageFlagsEphemeral
+		}
+		return res
+	case discordgo.InteractionMessageComponent:
+		switch i.MessageComponentData().CustomID {
+		case loi.ButtonReroll, loi.ButtonCancel, loi.ButtonLock, loi.ButtonReady:
+			return &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate}
+		}
+	}
+	return nil
+}
+
+// sendResult replaces the deferred response with the result. An ephemeral
+// result of a public command, like an error, is sent as an ephemeral follow
+// up once the deferred response is deleted.
+func sendResult(s responder, i *discordgo.InteractionCreate, deferred *discordgo.InteractionResponse, res *discordgo.InteractionResponse) error {
+	if deferred.Type == discordgo.InteractionResponseDeferredMessageUpdate {
+		if res == nil || res.Data == nil {
+			return nil
+		}
+		_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: res.Data.Content,
+			Flags:   res.Data.Flags,
+		})
+		return err
+	}
+	if res == nil || res.Data == nil {
+		return s.InteractionResponseDelete(i.Interaction)
+	}
+	if res.Data.Flags&discordgo.MessageFlagsEphemeral != 0 && deferred.Data.Flags&discordgo.MessageFlagsEphemeral == 0 {
+		if err := s.InteractionResponseDelete(i.Interaction); err != nil {
+			return err
+		}
+		_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: res.Data.Content,
+			Flags:   res.Data.Flags,
+		})
+		return err
+	}
+	_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:         &res.Data.Content,
+		AllowedMentions: res.Data.AllowedMentions,
+	})
+	return err
+}
+
+// interactionUser returns the user who sent the interaction, the member is
+// only set in guilds.
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// handleCommand runs the slash command of the interaction and returns the
+// response, nil is returned when the interaction is not a lobby command.
+func (b *bot) handleCommand(ctx context.Context, i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
+	cmd := i.ApplicationCommandData()
+	if cmd.Name != "loi" || len(cmd.Options) == 0 {
+		return nil
+	}
+	actor := loi.Actor{Source: actorSource}
+	if u := interactionUser(i); u != nil {
+		actor.PlayerID = u.ID
+	}
+	sub := cmd.Options[0]
+	slog.Info(fmt.Sprintf("[handleCommand] - '%s' sent /loi %s", actor.PlayerID, sub.Name))
+	switch sub.Name {
+	case "roll":
+		res, err := b.gm.Roll(ctx, actor, loi.RollOptions{})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return publicResponse(formatRoll(res.RollCount, res.Players))
+	case "cancel":
+		if err := b.gm.Cancel(ctx, actor); err != nil {
+			return errorResponse(err)
+		}
+		return publicResponse("The game has been cancelled.")
+	case "reset":
+		if err := b.gm.Reset(ctx, actor); err != nil {
+			return errorResponse(err)
+		}
+		return publicResponse("The lobby has been reset.")
+	case "status":
+		return publicResponse(formatStatus(b.gm.State()))
+	case "pool":
+		if len(sub.Options) == 0 {
+			return nil
+		}
+		op := sub.Options[0]
+		champion := ""
+		if len(op.Options) > 0 {
+			champion = op.Options[0].StringValue()
+		}
+		switch op.Name {
+		case "add":
+			c, err := b.gm.AddToPool(ctx, actor, champion)
+			if err != nil {
+				return errorResponse(err)
+			}
+			return ephemeralResponse(fmt.Sprintf("%s has been added to your pool.", c.Name))
+		case "remove":
+			c, err := b.gm.RemoveFromPool(ctx, actor, champion)
+			if err != nil {
+				return errorResponse(err)
+			}
+			return ephemeralResponse(fmt.Sprintf("%s has been removed from your pool.", c.Name))
+		}
+	case "channel":
+		if len(sub.Options) == 0 {
+			return nil
+		}
+		op := sub.Options[0]
+		switch op.Name {
+		case "list":
+			vcs, err := b.gm.VoiceChannels(ctx)
+			if err != nil {
+				return errorResponse(err)
+			}
+			return publicResponse(formatVoiceChannels(b.gm.State().DiscordGuildChannelID, vcs))
+		case "switch":
+			if len(op.Options) == 0 {
+				return nil
+			}
+			channelID := op.Options[0].ChannelValue(nil).ID
+			if err := b.gm.SwitchVoiceChannel(ctx, actor, channelID); err != nil {
+				return errorResponse(err)
+			}
+			return publicResponse(fmt.Sprintf("The lobby now tracks <#%s>.", channelID))
+		}
+	case "stats":
+		playerID := actor.PlayerID
+		name := "You"
+		if len(sub.Options) > 0 {
+			if u := sub.Options[0].UserValue(nil); u != nil {
+				playerID = u.ID
+				name = fmt.Sprintf("<@%s>", u.ID)
+			}
+		}
+		s, err := b.gm.Stats(ctx, playerID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return publicResponse(formatStats(name, s))
+	}
+	return nil
+}
+
+// handleComponent runs the action of a button of the roll message, the
+// message is edited by the game manager so a successful click is only
+// acknowledged.
+func (b *bot) handleComponent(ctx context.Context, i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
+	customID := i.MessageComponentData().CustomID
+	switch customID {
+	case loi.ButtonReroll, loi.ButtonCancel, loi.ButtonLock, loi.ButtonReady:
+	default:
+		return nil
+	}
+	actor := loi.Actor{Source: actorSource}
+	if u := interactionUser(i); u != nil {
+		actor.PlayerID = u.ID
+	}
+	slog.Info(fmt.Sprintf("[handleComponent] - '%s' clicked '%s'", actor.PlayerID, customID))
+	if !inGame(b.gm.State(), actor.PlayerID) {
+		return ephemeralResponse("You are not in the game.")
+	}
+	var err error
+	switch customID {
+	case loi.ButtonReroll:
+		_, err = b.gm.Roll(ctx, actor, loi.RollOptions{RerollOnly: true})
+	case loi.ButtonCancel:
+		err = b.gm.Cancel(ctx, actor)
+	case loi.ButtonLock:
+		var locked bool
+		if locked, err = b.gm.LockPick(ctx, actor); err == nil {
+			if locked {
+				return ephemeralResponse("Your pick is locked.")
+			}
+			return ephemeralResponse("Your pick is unlocked.")
+		}
+	case loi.ButtonReady:
+		err = b.gm.Ready(ctx, actor)
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate}
+}
+
+// inGame tells if the player is in one of the slots of the game.
+func inGame(gs model.GameState, playerID string) bool {
+	if playerID == "" {
+		return false
+	}
+	for _, p := range gs.Players {
+		if p.Player.ID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+func publicResponse(content string) *discordgo.InteractionResponse {
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	}
+}
+
+func ephemeralResponse(content string) *discordgo.InteractionResponse {
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	}
+}
+
+// errorResponse only shows the error to the user who sent the command, the
+// details of the internal errors are kept in the logs.
+func errorResponse(err error) *discordgo.InteractionResponse {
+	var ce *loi.CommandError
+	if errors.As(err, &ce) {
+		return ephemeralResponse(fmt.Sprintf("Unable to run the command : %s.", ce.Message))
+	}
+	slog.Error(fmt.Sprintf("[errorResponse] - command failed : %s", err.Error()))
+	return ephemeralResponse("Unable to run the command, something went wrong.")
+}
+
+func playerName(p model.DiscordPlayer) string {
+	if p.Name != nil && *p.Name != "" {
+		return *p.Name
+	}
+	return fmt.Sprintf("<@%s>", p.ID)
+}
+
+func formatPlayers(sb *strings.Builder, players []model.GamePlayer) {
+	for _, p := range players {
+		if p.Player.ID == "" {
+			continue
+		}
+		role := "-"
+		if p.Role != nil {
+			role = string(*p.Role)
+		}
+		champion := "-"
+		if p.Champion != nil {
+			champion = p.Champion.Name
+		}
+		fmt.Fprintf(sb, "\n**%s** : %s - %s", playerName(p.Player), role, champion)
+	}
+}
+
+func formatRoll(rollCount uint, players []model.GamePlayer) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Roll #%d**", rollCount)
+	formatPlayers(&sb, players)
+	return sb.String()
+}
+
+func formatStatus(gs model.GameState) string {
+	if !gs.GameInProgress {
+		return "No game in progress."
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Game #%d** - roll #%d", gs.GameId, gs.RollCount)
+	if !gs.CanRoll && gs.NextRollTimer > 0 {
+		fmt.Fprintf(&sb, ", next roll in %ds", (gs.NextRollTimer+999)/1000)
+	}
+	formatPlayers(&sb, gs.Players)
+	return sb.String()
+}
+
+func formatVoiceChannels(trackedID string, vcs []model.VoiceChannel) string {
+	if len(vcs) == 0 {
+		return "No voice channel found."
+	}
+	var sb strings.Builder
+	sb.WriteString("**Voice channels**")
+	for _, vc := range vcs {
+		fmt.Fprintf(&sb, "\n<#%s> (%d)", vc.ID, vc.Members)
+		if vc.ID == trackedID {
+			sb.WriteString(" - tracked")
+		}
+	}
+	return sb.String()
+}
+
+func formatStats(name string, s *loi.PlayerStats) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s played **%d** games with **%d** rolls, **%d** champions in the pool.", name, s.Games, s.Rolls, s.PoolSize)
+	if len(s.TopChampions) > 0 {
+		sb.WriteString("\nMost rolled :")
+		for _, c := range s.TopChampions {
+			fmt.Fprintf(&sb, "\n%s (%d)", c.Champion.Name, c.Count)
+		}
+	}
+	return sb.String()
+}
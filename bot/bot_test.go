@@ -0,0 +1,324 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/phturb/bonjack-tools-backend-go/loi"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGameManager only implements the commands used by the bot, the other
+// methods panic through the nil embedded interface.
+type fakeGameManager struct {
+	loi.GameManager
+	actors []loi.Actor
+	pool   map[string]bool
+}
+
+func (f *fakeGameManager) Roll(ctx context.Context, actor loi.Actor, opts loi.RollOptions) (*loi.RollResult, error) {
+	f.actors = append(f.actors, actor)
+	if actor.PlayerID != "host" {
+		return nil, loi.ErrForbidden
+	}
+	role := model.Role("MID")
+	name := "Host"
+	return &loi.RollResult{
+		GameID:    1,
+		RollCount: 2,
+		Players: []model.GamePlayer{
+			{Player: model.DiscordPlayer{ID: "host", Name: &name}, Role: &role, Champion: &model.Champion{Name: "Ryze"}},
+			model.NewEmptyGamePlayer(),
+		},
+	}, nil
+}
+
+func (f *fakeGameManager) Cancel(ctx context.Context, actor loi.Actor) error {
+	return fmt.Errorf("database is gone")
+}
+
+func (f *fakeGameManager) State() model.GameState {
+	gs := model.NewDefaultGameState(model.NewDefaultGameSettings(0))
+	gs.Players[0].Player.ID = "host"
+	return gs
+}
+
+func (f *fakeGameManager) VoiceChannels(ctx context.Context) ([]model.VoiceChannel, error) {
+	return []model.VoiceChannel{{ID: "voice", Name: "Voice", Members: 2}, {ID: "lounge", Name: "Lounge"}}, nil
+}
+
+func (f *fakeGameManager) SwitchVoiceChannel(ctx context.Context, actor loi.Actor, channelID string) error {
+	if actor.PlayerID != "host" {
+		return loi.ErrForbidden
+	}
+	return nil
+}
+
+func (f *fakeGameManager) LockPick(ctx context.Context, actor loi.Actor) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeGameManager) Ready(ctx context.Context, actor loi.Actor) error {
+	return loi.ErrNoReadyCheck
+}
+
+func (f *fakeGameManager) AddToPool(ctx context.Context, actor loi.Actor, champion string) (*model.Champion, error) {
+	if champion != "Ashe" {
+		return nil, fmt.Errorf("%w : '%s'", loi.ErrChampionNotFound, champion)
+	}
+	f.pool[actor.PlayerID+"/"+champion] = true
+	return &model.Champion{Name: champion}, nil
+}
+
+func (f *fakeGameManager) Stats(ctx context.Context, playerID string) (*loi.PlayerStats, error) {
+	return &loi.PlayerStats{
+		PlayerID: playerID,
+		Games:    3,
+		Rolls:    5,
+		TopChampions: []loi.ChampionCount{
+			{Champion: model.Champion{Name: "Ashe"}, Count: 2},
+		},
+	}, nil
+}
+
+func interaction(userID string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			Type:   discordgo.InteractionApplicationCommand,
+			Member: &discordgo.Member{User: &discordgo.User{ID: userID}},
+			Data: discordgo.ApplicationCommandInteractionData{
+				Name:    "loi",
+				Options: options,
+			},
+		},
+	}
+}
+
+func subcommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:    name,
+		Type:    discordgo.ApplicationCommandOptionSubCommand,
+		Options: options,
+	}
+}
+
+func TestSlashCommands(t *testing.T) {
+	gm := &fakeGameManager{pool: make(map[string]bool)}
+	b := &bot{gm: gm}
+	ctx := context.Background()
+
+	t.Run("Results are public", func(t *testing.T) {
+		res := b.handleCommand(ctx, interaction("host", subcommand("roll")))
+		assert.Zero(t, res.Data.Flags)
+		assert.Equal(t, "**Roll #2**\n**Host** : MID - Ryze", res.Data.Content)
+		assert.Equal(t, []loi.Actor{{PlayerID: "host", Source: "discord"}}, gm.actors)
+
+		res = b.handleCommand(ctx, interaction("host", subcommand("status")))
+		assert.Zero(t, res.Data.Flags)
+		assert.Equal(t, "No game in progress.", res.Data.Content)
+	})
+
+	t.Run("Errors are ephemeral", func(t *testing.T) {
+		res := b.handleCommand(ctx, interaction("player", subcommand("roll")))
+		assert.Equal(t, discordgo.MessageFlagsEphemeral, res.Data.Flags)
+		assert.Equal(t, "Unable to run the command : not allowed to change the game state.", res.Data.Content)
+
+		res = b.handleCommand(ctx, interaction("host", subcommand("cancel")))
+		assert.Equal(t, discordgo.MessageFlagsEphemeral, res.Data.Flags)
+		assert.Equal(t, "Unable to run the command, something went wrong.", res.Data.Content)
+	})
+
+	t.Run("Pool changes the pool of the sender", func(t *testing.T) {
+		pool := func(champion string) *discordgo.InteractionCreate {
+			return interaction("player", &discordgo.ApplicationCommandInteractionDataOption{
+				Name: "pool",
+				Type: discordgo.ApplicationCommandOptionSubCommandGroup,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					subcommand("add", &discordgo.ApplicationCommandInteractionDataOption{
+						Name:  "champion",
+						Type:  discordgo.ApplicationCommandOptionString,
+						Value: champion,
+					}),
+				},
+			})
+		}
+		res := b.handleCommand(ctx, pool("Ashe"))
+		assert.Equal(t, discordgo.MessageFlagsEphemeral, res.Data.Flags)
+		assert.Equal(t, "Ashe has been added to your pool.", res.Data.Content)
+		assert.True(t, gm.pool["player/Ashe"])
+
+		res = b.handleCommand(ctx, pool("Teemo"))
+		assert.Equal(t, "Unable to run the command : champion not found.", res.Data.Content)
+	})
+
+	t.Run("Voice channels are listed and switched by the host", func(t *testing.T) {
+		channel := func(userID string, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+			return interaction(userID, &discordgo.ApplicationCommandInteractionDataOption{
+				Name:    "channel",
+				Type:    discordgo.ApplicationCommandOptionSubCommandGroup,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{subcommand(name, options...)},
+			})
+		}
+		res := b.handleCommand(ctx, channel("player", "list"))
+		assert.Equal(t, "**Voice channels**\n<#voice> (2)\n<#lounge> (0)", res.Data.Content)
+
+		lounge := &discordgo.ApplicationCommandInteractionDataOption{
+			Name:  "channel",
+			Type:  discordgo.ApplicationCommandOptionChannel,
+			Value: "lounge",
+		}
+		res = b.handleCommand(ctx, channel("player", "switch", lounge))
+		assert.Equal(t, discordgo.MessageFlagsEphemeral, res.Data.Flags)
+		res = b.handleCommand(ctx, channel("host", "switch", lounge))
+		assert.Equal(t, "The lobby now tracks <#lounge>.", res.Data.Content)
+	})
+
+	t.Run("Stats default to the sender", func(t *testing.T) {
+		res := b.handleCommand(ctx, interaction("player", subcommand("stats")))
+		assert.Equal(t, "You played **3** games with **5** rolls, **0** champions in the pool.\nMost rolled :\nAshe (2)", res.Data.Content)
+
+		res = b.handleCommand(ctx, interaction("player", subcommand("stats", &discordgo.ApplicationCommandInteractionDataOption{
+			Name:  "player",
+			Type:  discordgo.ApplicationCommandOptionUser,
+			Value: "other",
+		})))
+		assert.Contains(t, res.Data.Content, "<@other> played")
+	})
+}
+
+func click(userID string, customID string) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			Type:   discordgo.InteractionMessageComponent,
+			Member: &discordgo.Member{User: &discordgo.User{ID: userID}},
+			Data:   discordgo.MessageComponentInteractionData{CustomID: customID},
+		},
+	}
+}
+
+func TestRollButtons(t *testing.T) {
+	gm := &fakeGameManager{pool: make(map[string]bool)}
+	b := &bot{gm: gm}
+	ctx := context.Background()
+
+	t.Run("Only the players in the game can click", func(t *testing.T) {
+		res := b.handleComponent(ctx, click("spectator", loi.ButtonReroll))
+		assert.Equal(t, discordgo.MessageFlagsEphemeral, res.Data.Flags)
+		assert.Equal(t, "You are not in the game.", res.Data.Content)
+		assert.Empty(t, gm.actors)
+	})
+
+	t.Run("Successful clicks are acknowledged", func(t *testing.T) {
+		res := b.handleComponent(ctx, click("host", loi.ButtonReroll))
+		assert.Equal(t, discordgo.InteractionResponseDeferredMessageUpdate, res.Type)
+		assert.Equal(t, []loi.Actor{{PlayerID: "host", Source: "discord"}}, gm.actors)
+
+		res = b.handleComponent(ctx, click("host", loi.ButtonLock))
+		assert.Equal(t, "Your pick is locked.", res.Data.Content)
+	})
+
+	t.Run("Blocked actions answer with an ephemeral error", func(t *testing.T) {
+		res := b.handleComponent(ctx, click("host", loi.ButtonReady))
+		assert.Equal(t, discordgo.MessageFlagsEphemeral, res.Data.Flags)
+		assert.Equal(t, "Unable to run the command : "+loi.ErrNoReadyCheck.Message+".", res.Data.Content)
+
+		assert.Nil(t, b.handleComponent(ctx, click("host", "other:button")))
+	})
+}
+
+// fakeResponder records the answers to the interactions, ran holds the
+// number of commands run by the game manager when the interaction was
+// deferred.
+type fakeResponder struct {
+	gm    *fakeGameManager
+	calls []string
+	ran   int
+}
+
+func (f *fakeResponder) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	f.ran = len(f.gm.actors)
+	flags := discordgo.MessageFlags(0)
+	if resp.Data != nil {
+		flags = resp.Data.Flags
+	}
+	f.calls = append(f.calls, fmt.Sprintf("defer %d %d", resp.Type, flags))
+	return nil
+}
+
+func (f *fakeResponder) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.calls = append(f.calls, "edit "+*newresp.Content)
+	return &discordgo.Message{}, nil
+}
+
+func (f *fakeResponder) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	f.calls = append(f.calls, "delete")
+	return nil
+}
+
+func (f *fakeResponder) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.calls = append(f.calls, fmt.Sprintf("followup %d %s", data.Flags, data.Content))
+	return &discordgo.Message{}, nil
+}
+
+func TestDeferredInteractions(t *testing.T) {
+	gm := &fakeGameManager{pool: make(map[string]bool)}
+	b := &bot{gm: gm}
+	public := fmt.Sprintf("defer %d 0", discordgo.InteractionResponseDeferredChannelMessageWithSource)
+	ephemeral := discordgo.MessageFlagsEphemeral
+
+	t.Run("Commands are deferred before they run", func(t *testing.T) {
+		s := &fakeResponder{gm: gm}
+		b.interact(s, interaction("host", subcommand("roll")))
+		assert.Equal(t, 0, s.ran)
+		assert.Equal(t, []string{public, "edit **Roll #2**\n**Host** : MID - Ryze"}, s.calls)
+	})
+
+	t.Run("Errors of public commands are sent as ephemeral follow ups", func(t *testing.T) {
+		s := &fakeResponder{gm: gm}
+		b.interact(s, interaction("player", subcommand("roll")))
+		assert.Equal(t, []string{
+			public,
+			"delete",
+			fmt.Sprintf("followup %d Unable to run the command : not allowed to change the game state.", ephemeral),
+		}, s.calls)
+	})
+
+	t.Run("Pool commands are deferred as ephemeral", func(t *testing.T) {
+		s := &fakeResponder{gm: gm}
+		b.interact(s, interaction("player", &discordgo.ApplicationCommandInteractionDataOption{
+			Name: "pool",
+			Type: discordgo.ApplicationCommandOptionSubCommandGroup,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				subcommand("add", &discordgo.ApplicationCommandInteractionDataOption{
+					Name:  "champion",
+					Type:  discordgo.ApplicationCommandOptionString,
+					Value: "Ashe",
+				}),
+			},
+		}))
+		assert.Equal(t, []string{
+			fmt.Sprintf("defer %d %d", discordgo.InteractionResponseDeferredChannelMessageWithSource, ephemeral),
+			"edit Ashe has been added to your pool.",
+		}, s.calls)
+	})
+
+	t.Run("Clicks only follow up with their message", func(t *testing.T) {
+		update := fmt.Sprintf("defer %d 0", discordgo.InteractionResponseDeferredMessageUpdate)
+		s := &fakeResponder{gm: gm}
+		b.interact(s, click("host", loi.ButtonReroll))
+		assert.Equal(t, []string{update}, s.calls)
+
+		s = &fakeResponder{gm: gm}
+		b.interact(s, click("host", loi.ButtonLock))
+		assert.Equal(t, []string{update, fmt.Sprintf("followup %d Your pick is locked.", ephemeral)}, s.calls)
+	})
+
+	t.Run("Other interactions are ignored", func(t *testing.T) {
+		s := &fakeResponder{gm: gm}
+		b.interact(s, click("host", "other:button"))
+		assert.Empty(t, s.calls)
+	})
+}
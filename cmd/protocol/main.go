@@ -0,0 +1,51 @@
+// Command protocol writes the JSON schema and the TypeScript definitions of
+// the lobby protocol.
+//
+//	go run ./cmd/protocol -schema schema.json -ts protocol.ts
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+)
+
+func write(path string, content []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+	return os.WriteFile(path, content, 0o644)
+}
+
+func main() {
+	schemaPath := flag.String("schema", "", "file the JSON schema is written to, - for stdout")
+	tsPath := flag.String("ts", "", "file the TypeScript definitions are written to, - for stdout")
+	flag.Parse()
+	if *schemaPath == "" && *tsPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	doc := modelwebsocket.ProtocolSchema()
+	if *schemaPath != "" {
+		raw, err := json.MarshalIndent(doc, "", "  ")
+		if err == nil {
+			err = write(*schemaPath, append(raw, '\n'))
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("[protocol] - failed to write the schema : %s", err.Error()))
+			os.Exit(1)
+		}
+	}
+	if *tsPath != "" {
+		if err := write(*tsPath, []byte(modelwebsocket.TypeScript(doc))); err != nil {
+			slog.Error(fmt.Sprintf("[protocol] - failed to write the TypeScript definitions : %s", err.Error()))
+			os.Exit(1)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package discord
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/bwmarrin/discordgo"
@@ -19,6 +22,49 @@ type discordManager struct {
 type DiscordManager interface {
 	Session() *discordgo.Session
 	GetConfigChannel() (*discordgo.Channel, error)
+	SendTextMessage(content string) error
+	// SendEmbeds posts the embeds and their components in the configured
+	// text channel and returns the message id, the id is empty when no text
+	// channel is configured.
+	SendEmbeds(embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) (string, error)
+	// EditEmbeds replaces the embeds and the components of a message posted
+	// with SendEmbeds.
+	EditEmbeds(messageID string, embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) error
+	// CheckPermissions returns ErrMissingPermission when the bot is missing
+	// one of the permissions in the channel.
+	CheckPermissions(channelID string, permissions int64) error
+	// EnsureVoiceChannel returns the voice channel with the name next to
+	// another channel, the channel is created when it does not exist.
+	EnsureVoiceChannel(guildID string, nextToChannelID string, name string) (*discordgo.Channel, error)
+	// VoiceChannelID returns the voice channel of a member, empty when the
+	// member is not connected.
+	VoiceChannelID(guildID string, userID string) string
+	// MoveMember moves a connected member to another voice channel.
+	MoveMember(guildID string, userID string, channelID string) error
+	// Member returns a member of the guild from the state cache, the member
+	// is fetched when it is missing.
+	Member(guildID string, userID string) (*discordgo.Member, error)
+	// EnsureRole returns the guild role with the name, the role is created
+	// when it does not exist.
+	EnsureRole(guildID string, name string) (*discordgo.Role, error)
+	AddMemberRole(guildID string, userID string, roleID string) error
+	RemoveMemberRole(guildID string, userID string, roleID string) error
+	// SetNickname changes the nickname of a member, an empty nickname
+	// resets it.
+	SetNickname(guildID string, userID string, nickname string) error
+}
+
+// ErrMissingPermission is returned when the bot is not allowed to do an action
+// in a channel.
+var ErrMissingPermission = errors.New("missing permission")
+
+var permissionNames = map[int64]string{
+	discordgo.PermissionVoiceMoveMembers: "Move Members",
+	discordgo.PermissionVoiceConnect:     "Connect",
+	discordgo.PermissionManageChannels:   "Manage Channels",
+	discordgo.PermissionViewChannel:      "View Channel",
+	discordgo.PermissionManageRoles:      "Manage Roles",
+	discordgo.PermissionManageNicknames:  "Manage Nicknames",
 }
 
 var _ DiscordManager = (*discordManager)(nil)
@@ -66,3 +112,167 @@ func (d *discordManager) GetConfigChannel() (*discordgo.Channel, error) {
 	}
 	return ch, nil
 }
+
+// SendTextMessage posts a message in the configured text channel, nothing is
+// sent when no text channel is configured.
+func (d *discordManager) SendTextMessage(content string) error {
+	channelID := internal.Config().Discord.TextChannelID
+	if channelID == "" {
+		slog.Debug("[SendTextMessage] - no text channel configured, skipping message")
+		return nil
+	}
+	_, err := d.session.ChannelMessageSend(channelID, content)
+	return err
+}
+
+// SendEmbeds implements DiscordManager.
+func (d *discordManager) SendEmbeds(embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) (string, error) {
+	channelID := internal.Config().Discord.TextChannelID
+	if channelID == "" {
+		slog.Debug("[SendEmbeds] - no text channel configured, skipping message")
+		return "", nil
+	}
+	m, err := d.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embeds:     embeds,
+		Components: components,
+	})
+	if err != nil {
+		return "", err
+	}
+	return m.ID, nil
+}
+
+// EditEmbeds implements DiscordManager.
+func (d *discordManager) EditEmbeds(messageID string, embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) error {
+	channelID := internal.Config().Discord.TextChannelID
+	if channelID == "" {
+		slog.Debug("[EditEmbeds] - no text channel configured, skipping message")
+		return nil
+	}
+	// the components are always sent so they are removed when empty
+	if components == nil {
+		components = []discordgo.MessageComponent{}
+	}
+	_, err := d.session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         messageID,
+		Channel:    channelID,
+		Embeds:     &embeds,
+		Components: &components,
+	})
+	return err
+}
+
+// CheckPermissions implements DiscordManager.
+func (d *discordManager) CheckPermissions(channelID string, permissions int64) error {
+	if d.session.State.User == nil {
+		return fmt.Errorf("%w : bot is not connected", ErrMissingPermission)
+	}
+	perms, err := d.session.State.UserChannelPermissions(d.session.State.User.ID, channelID)
+	if err != nil {
+		return fmt.Errorf("unable to read the permissions in channel '%s' : %w", channelID, err)
+	}
+	missing := permissions &^ perms
+	if missing == 0 {
+		return nil
+	}
+	names := make([]string, 0)
+	for p, name := range permissionNames {
+		if missing&p != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%w : %s in channel '%s'", ErrMissingPermission, strings.Join(names, ", "), channelID)
+}
+
+// EnsureVoiceChannel implements DiscordManager.
+func (d *discordManager) EnsureVoiceChannel(guildID string, nextToChannelID string, name string) (*discordgo.Channel, error) {
+	next, err := d.session.State.Channel(nextToChannelID)
+	if err != nil {
+		return nil, err
+	}
+	guild, err := d.session.State.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	d.session.State.RLock()
+	for _, c := range guild.Channels {
+		if c.Type == discordgo.ChannelTypeGuildVoice && c.ParentID == next.ParentID && c.Name == name {
+			d.session.State.RUnlock()
+			return c, nil
+		}
+	}
+	d.session.State.RUnlock()
+	if err := d.CheckPermissions(nextToChannelID, discordgo.PermissionManageChannels); err != nil {
+		return nil, err
+	}
+	slog.Info(fmt.Sprintf("[EnsureVoiceChannel] - creating voice channel '%s'", name))
+	return d.session.GuildChannelCreateComplex(guildID, discordgo.GuildChannelCreateData{
+		Name:     name,
+		Type:     discordgo.ChannelTypeGuildVoice,
+		ParentID: next.ParentID,
+	})
+}
+
+// VoiceChannelID implements DiscordManager.
+func (d *discordManager) VoiceChannelID(guildID string, userID string) string {
+	vs, err := d.session.State.VoiceState(guildID, userID)
+	if err != nil {
+		return ""
+	}
+	return vs.ChannelID
+}
+
+// MoveMember implements DiscordManager.
+func (d *discordManager) MoveMember(guildID string, userID string, channelID string) error {
+	return d.session.GuildMemberMove(guildID, userID, &channelID)
+}
+
+// Member implements DiscordManager.
+func (d *discordManager) Member(guildID string, userID string) (*discordgo.Member, error) {
+	if m, err := d.session.State.Member(guildID, userID); err == nil {
+		return m, nil
+	}
+	m, err := d.session.GuildMember(guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.session.State.MemberAdd(m); err != nil {
+		slog.Warn(fmt.Sprintf("[Member] - failed to cache member '%s' : %s", userID, err.Error()))
+	}
+	return m, nil
+}
+
+// EnsureRole implements DiscordManager.
+func (d *discordManager) EnsureRole(guildID string, name string) (*discordgo.Role, error) {
+	roles, err := d.session.GuildRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	slog.Info(fmt.Sprintf("[EnsureRole] - creating role '%s'", name))
+	mentionable := false
+	return d.session.GuildRoleCreate(guildID, &discordgo.RoleParams{
+		Name:        name,
+		Mentionable: &mentionable,
+	})
+}
+
+// AddMemberRole implements DiscordManager.
+func (d *discordManager) AddMemberRole(guildID string, userID string, roleID string) error {
+	return d.session.GuildMemberRoleAdd(guildID, userID, roleID)
+}
+
+// RemoveMemberRole implements DiscordManager.
+func (d *discordManager) RemoveMemberRole(guildID string, userID string, roleID string) error {
+	return d.session.GuildMemberRoleRemove(guildID, userID, roleID)
+}
+
+// SetNickname implements DiscordManager.
+func (d *discordManager) SetNickname(guildID string, userID string, nickname string) error {
+	return d.session.GuildMemberNickname(guildID, userID, nickname)
+}
@@ -0,0 +1,81 @@
+package discord
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+// redirect sends the requests of the discord api to a test server.
+type redirect struct {
+	target *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	req.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// setupTest returns a manager with a guild in the state cache, the discord
+// api is served by the handler.
+func setupTest(t *testing.T, handler http.HandlerFunc) *discordManager {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+
+	ds, err := discordgo.New("Bot test")
+	assert.NoError(t, err)
+	ds.Client = &http.Client{Transport: redirect{target: target}}
+	ds.MaxRestRetries = 0
+	assert.NoError(t, ds.State.GuildAdd(&discordgo.Guild{ID: "test-guild"}))
+	return &discordManager{session: ds}
+}
+
+func TestMember(t *testing.T) {
+	var fetched atomic.Int32
+	dm := setupTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/guilds/test-guild/members/player1") {
+			http.NotFound(w, r)
+			return
+		}
+		fetched.Add(1)
+		json.NewEncoder(w).Encode(discordgo.Member{
+			User: &discordgo.User{ID: "player1", Username: "Player 1"},
+			Nick: "P1",
+		})
+	})
+
+	t.Run("A missing member is fetched and cached", func(t *testing.T) {
+		m, err := dm.Member("test-guild", "player1")
+		assert.NoError(t, err)
+		assert.Equal(t, "P1", m.Nick)
+		assert.Equal(t, int32(1), fetched.Load())
+
+		cached, err := dm.session.State.Member("test-guild", "player1")
+		assert.NoError(t, err)
+		assert.Equal(t, "P1", cached.Nick)
+	})
+
+	t.Run("A cached member is not fetched", func(t *testing.T) {
+		m, err := dm.Member("test-guild", "player1")
+		assert.NoError(t, err)
+		assert.Equal(t, "Player 1", m.User.Username)
+		assert.Equal(t, int32(1), fetched.Load())
+	})
+
+	t.Run("An unknown member returns the error", func(t *testing.T) {
+		_, err := dm.Member("test-guild", "player2")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,209 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/roster"
+)
+
+// Roster is the roster of the members connected to the lobby voice channels
+// of the configured guild.
+type Roster struct {
+	session *discordgo.Session
+	guildID string
+
+	mu     sync.RWMutex
+	lobby  map[string]bool
+	events roster.Events
+}
+
+var _ roster.Lobby = (*Roster)(nil)
+
+func NewRoster(session *discordgo.Session) *Roster {
+	r := &Roster{
+		session: session,
+		guildID: internal.Config().Discord.GuildID,
+		lobby:   map[string]bool{},
+	}
+	session.AddHandler(r.onGuildCreate)
+	session.AddHandler(r.onVoiceStateUpdate)
+	session.AddHandler(r.onGuildMemberUpdate)
+	session.AddHandler(r.onGuildMemberAdd)
+	session.AddHandler(r.onGuildMemberRemove)
+	return r
+}
+
+// rosterMember names the member the same way everywhere, the guild nickname
+// first, then the discord display name and the username.
+func rosterMember(m *discordgo.Member) roster.Member {
+	name := m.DisplayName()
+	if name == "" {
+		name = m.User.Username
+	}
+	rm := roster.Member{
+		ID:   m.User.ID,
+		Name: name,
+	}
+	if m.User.Avatar != "" {
+		avatar := m.User.Avatar
+		rm.Avatar = &avatar
+	}
+	return rm
+}
+
+// Subscribe implements roster.Provider.
+func (r *Roster) Subscribe(events roster.Events) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = events
+}
+
+// SetLobby implements roster.Lobby, the ids are voice channels.
+func (r *Roster) SetLobby(ids []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lobby = map[string]bool{}
+	for _, id := range ids {
+		if id != "" {
+			r.lobby[id] = true
+		}
+	}
+}
+
+func (r *Roster) inLobby(channelID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lobby[channelID]
+}
+
+func (r *Roster) subscriber() roster.Events {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.events
+}
+
+// Snapshot implements roster.Provider.
+func (r *Roster) Snapshot(ctx context.Context) ([]roster.Member, error) {
+	r.mu.RLock()
+	empty := len(r.lobby) == 0
+	r.mu.RUnlock()
+	if empty {
+		return nil, errors.New("no voice channel is tracked")
+	}
+	guild, err := r.session.State.Guild(r.guildID)
+	if err != nil {
+		return nil, err
+	}
+	r.session.State.RLock()
+	vss := make([]discordgo.VoiceState, 0, len(guild.VoiceStates))
+	for _, vs := range guild.VoiceStates {
+		if r.inLobby(vs.ChannelID) {
+			vss = append(vss, *vs)
+		}
+	}
+	r.session.State.RUnlock()
+
+	slog.Info(fmt.Sprintf("[Snapshot] - found %d members in the lobby", len(vss)))
+	members := make([]roster.Member, 0, len(vss))
+	for _, vs := range vss {
+		m, err := r.session.State.Member(r.guildID, vs.UserID)
+		if err != nil {
+			slog.Info(fmt.Sprintf("[Snapshot] - member '%s' is not in the state cache, fetching it", vs.UserID))
+			m, err = r.session.GuildMember(r.guildID, vs.UserID, discordgo.WithContext(ctx))
+			if err != nil {
+				slog.Warn(fmt.Sprintf("[Snapshot] - failed to fetch member '%s' : %s", vs.UserID, err.Error()))
+				continue
+			}
+			if err := r.session.State.MemberAdd(m); err != nil {
+				slog.Warn(fmt.Sprintf("[Snapshot] - failed to cache member '%s' : %s", vs.UserID, err.Error()))
+			}
+		}
+		if m.User == nil || m.User.ID == "" {
+			slog.Warn("[Snapshot] - member is missing user, can't identify user id")
+			continue
+		}
+		members = append(members, rosterMember(m))
+	}
+	return members, nil
+}
+
+func (r *Roster) onGuildCreate(s *discordgo.Session, e *discordgo.GuildCreate) {
+	if e.Guild == nil || e.ID != r.guildID {
+		return
+	}
+	events := r.subscriber()
+	if events == nil {
+		return
+	}
+	slog.Info("[onGuildCreate] - looking for members already in the voice channel")
+	inChannel := map[string]bool{}
+	for _, vs := range e.VoiceStates {
+		if !r.inLobby(vs.ChannelID) {
+			continue
+		}
+		inChannel[vs.UserID] = true
+		slog.Info(fmt.Sprintf("[onGuildCreate] - found user id '%s' in voice channel", vs.UserID))
+	}
+	if len(inChannel) == 0 {
+		slog.Warn("[onGuildCreate] - no members found in voice channel")
+	}
+	members := make([]roster.Member, 0, len(inChannel))
+	for _, m := range e.Members {
+		if m.User != nil && inChannel[m.User.ID] {
+			members = append(members, rosterMember(m))
+		}
+	}
+	events.RosterSet(members)
+}
+
+func (r *Roster) onVoiceStateUpdate(s *discordgo.Session, u *discordgo.VoiceStateUpdate) {
+	if u.VoiceState == nil || u.GuildID != r.guildID {
+		return
+	}
+	events := r.subscriber()
+	if events == nil {
+		return
+	}
+	if !r.inLobby(u.ChannelID) {
+		events.MemberLeft(u.UserID)
+		return
+	}
+	if u.Member == nil || u.Member.User == nil {
+		slog.Warn(fmt.Sprintf("[onVoiceStateUpdate] - member '%s' is missing from the voice state", u.UserID))
+		return
+	}
+	events.MemberJoined(rosterMember(u.Member))
+}
+
+func (r *Roster) onGuildMemberUpdate(s *discordgo.Session, e *discordgo.GuildMemberUpdate) {
+	if e.Member == nil || e.User == nil || e.GuildID != r.guildID {
+		return
+	}
+	if events := r.subscriber(); events != nil {
+		events.MemberUpdated(rosterMember(e.Member))
+	}
+}
+
+func (r *Roster) onGuildMemberAdd(s *discordgo.Session, e *discordgo.GuildMemberAdd) {
+	if e.Member == nil || e.User == nil || e.GuildID != r.guildID {
+		return
+	}
+	if events := r.subscriber(); events != nil {
+		events.MemberUpdated(rosterMember(e.Member))
+	}
+}
+
+func (r *Roster) onGuildMemberRemove(s *discordgo.Session, e *discordgo.GuildMemberRemove) {
+	if e.Member == nil || e.User == nil || e.GuildID != r.guildID {
+		return
+	}
+	if events := r.subscriber(); events != nil {
+		events.MemberLeft(e.User.ID)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/joho/godotenv"
@@ -16,13 +17,60 @@ type apiKeys struct {
 }
 
 type gameManager struct {
-	TimerTime uint `json:"timerTime"`
+	TimerTime         uint `json:"timerTime"`
+	ReadyCheckTime    uint `json:"readyCheckTime"`
+	PlayersCanControl bool `json:"playersCanControl"`
+	// LobbyID is the id of the lobby when it is not a discord guild.
+	LobbyID string `json:"lobbyId"`
 }
 
 type discord struct {
-	Token     string `json:"token"`
-	ChannelID string `json:"channelId"`
-	GuildID   string `json:"guildId"`
+	Token         string `json:"token"`
+	ChannelID     string `json:"channelId"`
+	TextChannelID string `json:"textChannelId"`
+	GuildID       string `json:"guildId"`
+	// TeamChannelIDs are the voice channels of the teams, the channels are
+	// created next to the lobby channel when not configured.
+	TeamChannelIDs []string `json:"teamChannelIds"`
+}
+
+type auth struct {
+	ClientID      string `json:"clientId"`
+	ClientSecret  string `json:"-"`
+	RedirectURL   string `json:"redirectUrl"`
+	AuthorizeURL  string `json:"authorizeUrl"`
+	TokenURL      string `json:"tokenUrl"`
+	UserURL       string `json:"userUrl"`
+	SessionSecret string `json:"-"`
+	SessionTTL    uint   `json:"sessionTtl"`
+	// AdminToken logs in the admin that hosts the lobby without discord.
+	AdminToken string `json:"-"`
+}
+
+func envOr(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func newAuth() auth {
+	sessionTTL, err := strconv.Atoi(os.Getenv("SESSION_TTL"))
+	if err != nil || sessionTTL <= 0 {
+		slog.Warn("[Auth] - failed to find value for SESSION_TTL, using fallback value")
+		sessionTTL = 7 * 24 * 60 * 60 * 1000
+	}
+	return auth{
+		ClientID:      os.Getenv("DISCORD_CLIENT_ID"),
+		ClientSecret:  os.Getenv("DISCORD_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("DISCORD_REDIRECT_URL"),
+		AuthorizeURL:  envOr("DISCORD_AUTHORIZE_URL", "https://discord.com/oauth2/authorize"),
+		TokenURL:      envOr("DISCORD_TOKEN_URL", "https://discord.com/api/oauth2/token"),
+		UserURL:       envOr("DISCORD_USER_URL", "https://discord.com/api/users/@me"),
+		SessionSecret: os.Getenv("SESSION_SECRET"),
+		SessionTTL:    uint(sessionTTL),
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
+	}
 }
 
 type database struct {
@@ -35,7 +83,43 @@ type database struct {
 }
 
 type server struct {
-	Port string `json:"port"`
+	Port           string   `json:"port"`
+	AllowedOrigins []string `json:"allowedOrigins"`
+	PingInterval   uint     `json:"pingInterval"`
+	IdleTimeout    uint     `json:"idleTimeout"`
+	RateLimit      uint     `json:"rateLimit"`
+	RateBurst      uint     `json:"rateBurst"`
+}
+
+func newServer() server {
+	pingInterval, err := strconv.Atoi(os.Getenv("WEBSOCKET_PING_INTERVAL"))
+	if err != nil || pingInterval <= 0 {
+		slog.Warn("[Server] - failed to find value for WEBSOCKET_PING_INTERVAL, using fallback value")
+		pingInterval = 30 * 1000
+	}
+	idleTimeout, err := strconv.Atoi(os.Getenv("WEBSOCKET_IDLE_TIMEOUT"))
+	if err != nil || idleTimeout < 0 {
+		slog.Warn("[Server] - failed to find value for WEBSOCKET_IDLE_TIMEOUT, using fallback value")
+		idleTimeout = 60 * 60 * 1000
+	}
+	rateLimit, err := strconv.Atoi(os.Getenv("WEBSOCKET_RATE_LIMIT"))
+	if err != nil || rateLimit <= 0 {
+		slog.Warn("[Server] - failed to find value for WEBSOCKET_RATE_LIMIT, using fallback value")
+		rateLimit = 5
+	}
+	rateBurst, err := strconv.Atoi(os.Getenv("WEBSOCKET_RATE_BURST"))
+	if err != nil || rateBurst <= 0 {
+		slog.Warn("[Server] - failed to find value for WEBSOCKET_RATE_BURST, using fallback value")
+		rateBurst = 20
+	}
+	return server{
+		Port:           os.Getenv("PORT"),
+		AllowedOrigins: splitList(os.Getenv("ALLOWED_ORIGINS")),
+		PingInterval:   uint(pingInterval),
+		IdleTimeout:    uint(idleTimeout),
+		RateLimit:      uint(rateLimit),
+		RateBurst:      uint(rateBurst),
+	}
 }
 
 func newGameManager() gameManager {
@@ -44,16 +128,41 @@ func newGameManager() gameManager {
 		slog.Warn("[GameManager] - failed to find value for TIMER_TIME, using fallback value")
 		timerTime = 60 * 1000 * 5
 	}
+	readyCheckTime, err := strconv.Atoi(os.Getenv("READY_CHECK_TIME"))
+	if err != nil || readyCheckTime <= 0 {
+		slog.Warn("[GameManager] - failed to find value for READY_CHECK_TIME, using fallback value")
+		readyCheckTime = 30 * 1000
+	}
+	playersCanControl, err := strconv.ParseBool(os.Getenv("PLAYERS_CAN_CONTROL"))
+	if err != nil {
+		playersCanControl = false
+	}
 	return gameManager{
-		TimerTime: uint(timerTime),
+		TimerTime:         uint(timerTime),
+		ReadyCheckTime:    uint(readyCheckTime),
+		PlayersCanControl: playersCanControl,
+		LobbyID:           envOr("LOBBY_ID", "default"),
 	}
 }
 
+func splitList(v string) []string {
+	var l []string
+	for _, e := range strings.Split(v, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		l = append(l, e)
+	}
+	return l
+}
+
 type config struct {
 	ApiKeys     apiKeys
 	GameManager gameManager
 	Server      server
 	Discord     discord
+	Auth        auth
 	Database    database
 }
 
@@ -79,14 +188,15 @@ func LoadConfig(filename string) {
 			RiotApiKey: os.Getenv("RIOT_API_KEY"),
 		},
 		GameManager: newGameManager(),
-		Server: server{
-			Port: os.Getenv("PORT"),
-		},
+		Server:      newServer(),
 		Discord: discord{
-			Token:     os.Getenv("DISCORD_TOKEN"),
-			ChannelID: os.Getenv("DISCORD_CHANNEL_ID"),
-			GuildID:   os.Getenv("DISCORD_GUILD_ID"),
+			Token:          os.Getenv("DISCORD_TOKEN"),
+			ChannelID:      os.Getenv("DISCORD_CHANNEL_ID"),
+			TextChannelID:  os.Getenv("DISCORD_TEXT_CHANNEL_ID"),
+			GuildID:        os.Getenv("DISCORD_GUILD_ID"),
+			TeamChannelIDs: splitList(os.Getenv("DISCORD_TEAM_CHANNEL_IDS")),
 		},
+		Auth: newAuth(),
 		Database: database{
 			DatabaseName: os.Getenv("DATABASE_NAME"),
 			Username:     os.Getenv("DATABASE_USERNAME"),
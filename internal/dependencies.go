@@ -44,11 +44,15 @@ func NewDependencies(ctx context.Context) (Dependencies, error) {
 		&model.Player{},
 		&model.GamePlayer{},
 		&model.GamePlayerRoll{},
+		&model.GamePause{},
 		&model.Champion{},
 		&model.PlayerChampion{},
 		&model.WeeklyChampion{},
 		&model.LaneRole{},
 		&model.LeagueVersion{},
+		&model.LobbyChannel{},
+		&model.LaneAssignment{},
+		&model.TeamMove{},
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,117 @@
+package loi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// loadChannelID returns the voice channel saved for the configured guild, or
+// the configured channel when none was chosen.
+func (g *gameManager) loadChannelID(ctx context.Context) string {
+	guildID := internal.Config().Discord.GuildID
+	var lc sharedmodel.LobbyChannel
+	err := g.d.Database(ctx).First(&lc, "guild_id = ?", guildID).Error
+	if err == nil && lc.ChannelID != "" {
+		slog.Info(fmt.Sprintf("[loadChannelID] - tracking the saved voice channel '%s'", lc.ChannelID))
+		return lc.ChannelID
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.Error(fmt.Sprintf("[loadChannelID] - failed to load the saved voice channel : %s", err.Error()))
+	}
+	return internal.Config().Discord.ChannelID
+}
+
+// VoiceChannels implements GameManager.
+func (g *gameManager) VoiceChannels(ctx context.Context) ([]model.VoiceChannel, error) {
+	var guildID string
+	g.do(ctx, func() error {
+		guildID = g.gs.DiscordGuildID
+		return nil
+	})
+	if g.dm == nil || guildID == "" {
+		return nil, fmt.Errorf("%w : guild is not ready", ErrDiscordUnavailable)
+	}
+	s := g.dm.Session()
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", ErrDiscordUnavailable, err.Error())
+	}
+	s.State.RLock()
+	defer s.State.RUnlock()
+	members := map[string]int{}
+	for _, vs := range guild.VoiceStates {
+		members[vs.ChannelID]++
+	}
+	chs := make([]*discordgo.Channel, 0, len(guild.Channels))
+	for _, c := range guild.Channels {
+		if c.Type == discordgo.ChannelTypeGuildVoice {
+			chs = append(chs, c)
+		}
+	}
+	sort.SliceStable(chs, func(i, j int) bool {
+		return chs[i].Position < chs[j].Position
+	})
+	vcs := make([]model.VoiceChannel, 0, len(chs))
+	for _, c := range chs {
+		vcs = append(vcs, model.VoiceChannel{
+			ID:      c.ID,
+			Name:    c.Name,
+			Members: members[c.ID],
+		})
+	}
+	return vcs, nil
+}
+
+// SwitchVoiceChannel implements GameManager. The choice is saved so the lobby
+// keeps tracking the channel after a restart.
+func (g *gameManager) SwitchVoiceChannel(ctx context.Context, actor Actor, channelID string) error {
+	vcs, err := g.VoiceChannels(ctx)
+	if err != nil {
+		return err
+	}
+	var vc *model.VoiceChannel
+	for i := range vcs {
+		if vcs[i].ID == channelID {
+			vc = &vcs[i]
+			break
+		}
+	}
+	if vc == nil {
+		return fmt.Errorf("%w : '%s'", ErrChannelNotFound, channelID)
+	}
+	if err := g.do(ctx, func() error {
+		if g.lobbyRole(actor.PlayerID) != model.LobbyRoleHost {
+			return ErrForbidden
+		}
+		if g.gs.GameInProgress {
+			return ErrGameInProgress
+		}
+		if err := g.d.Database(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&sharedmodel.LobbyChannel{
+			GuildID:   g.gs.DiscordGuildID,
+			ChannelID: vc.ID,
+		}).Error; err != nil {
+			slog.Error(fmt.Sprintf("[SwitchVoiceChannel] - failed to save the voice channel : %s", err.Error()))
+			return err
+		}
+		slog.Info(fmt.Sprintf("[SwitchVoiceChannel] - '%s' switched the lobby from '%s' to '%s'", actor.PlayerID, g.channelID, vc.ID))
+		g.channelID = vc.ID
+		g.updateLobby()
+		g.gs.DiscordGuildChannelID = vc.ID
+		g.gs.DiscordGuildChannelName = vc.Name
+		g.broadcastState("[SwitchVoiceChannel]")
+		return nil
+	}); err != nil {
+		return err
+	}
+	return g.refreshMembers(ctx)
+}
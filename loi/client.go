@@ -0,0 +1,191 @@
+package loi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+)
+
+const (
+	// sendQueueSize is the number of messages waiting for a client before it
+	// is considered too slow and disconnected, dropping messages would break
+	// the patch sequence.
+	sendQueueSize = 64
+	// writeTimeout bounds a single write to a client.
+	writeTimeout = 10 * time.Second
+)
+
+// client is a registered websocket connection with the features negotiated
+// on hello, or an event stream when conn is nil. Its writer goroutine is the
+// only one writing to the connection.
+type client struct {
+	id       string
+	playerID string
+	addr     string
+	conn     *websocket.Conn
+	patches  bool
+	limiter  *limiter
+
+	// lastActivity is the unix nano time of the last message received from
+	// the client, the pongs are answered by the browsers without the user.
+	lastActivity atomic.Int64
+
+	send      chan modelwebsocket.Message
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+}
+
+func newClient(conn *websocket.Conn, playerID string, patches bool, l *limiter) *client {
+	id := make([]byte, 8)
+	rand.Read(id)
+	c := &client{
+		id:       hex.EncodeToString(id),
+		playerID: playerID,
+		conn:     conn,
+		patches:  patches,
+		limiter:  l,
+		send:     make(chan modelwebsocket.Message, sendQueueSize),
+		done:     make(chan struct{}),
+	}
+	if conn != nil {
+		c.addr = conn.RemoteAddr().String()
+	}
+	c.touch()
+	return c
+}
+
+// touch records activity from the client.
+func (c *client) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *client) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActivity.Load()))
+}
+
+// close stops the writer goroutine, the code and text are sent in the close
+// frame of the connection.
+func (c *client) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// enqueue never blocks, a client with a full queue is disconnected.
+func (c *client) enqueue(m modelwebsocket.Message) {
+	select {
+	case <-c.done:
+	case c.send <- m:
+	default:
+		slog.Warn(fmt.Sprintf("[enqueue] - client '%s' is too slow, disconnecting it", c.addr))
+		c.close(websocket.CloseTryAgainLater, "too slow to receive messages")
+	}
+}
+
+// register adds the client to the viewers and starts the writer goroutine of
+// a websocket client, the event streams write from their request. The other
+// connections receive the new viewer and the pending changes before the
+// client is added, it starts from a snapshot. The connection must not be read
+// yet, the pong handler keeps pushing the read deadline back. It must be
+// called from the game state loop.
+func (g *gameManager) register(c *client) {
+	if c.conn != nil {
+		pongWait := 2 * g.pingInterval
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.conn.SetPongHandler(func(string) error {
+			return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+	}
+	g.gs.Viewers[c.id] = model.Viewer{
+		ClientID:    c.id,
+		PlayerID:    c.playerID,
+		ConnectedAt: time.Now(),
+	}
+	g.broadcastState("[register]")
+	g.connsMu.Lock()
+	if c.conn != nil {
+		g.conns[c.conn] = c
+	} else {
+		g.streams[c] = true
+	}
+	g.connsMu.Unlock()
+	if c.conn != nil {
+		go g.writeLoop(c)
+	}
+}
+
+// writeLoop writes the queued messages and pings the client until the client
+// is closed or a write fails, the client is then removed and its connection
+// closed. Clients that did not send anything for the idle timeout are closed,
+// even when they still answer the pings.
+func (g *gameManager) writeLoop(c *client) {
+	defer g.unregister(c)
+	ping := time.NewTicker(g.pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText), time.Now().Add(time.Second))
+			return
+		case <-ping.C:
+			if g.idleTimeout > 0 && c.idleFor() > g.idleTimeout {
+				slog.Info(fmt.Sprintf("[writeLoop] - client '%s' is idle, disconnecting it", c.addr))
+				c.close(websocket.CloseGoingAway, "idle")
+				continue
+			}
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				slog.Warn(fmt.Sprintf("[writeLoop] - failed to ping client '%s' : %s", c.addr, err.Error()))
+				return
+			}
+		case m := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteJSON(m); err != nil {
+				slog.Warn(fmt.Sprintf("[writeLoop] - failed to write to client '%s' : %s", c.addr, err.Error()))
+				return
+			}
+		}
+	}
+}
+
+func (g *gameManager) unregister(c *client) {
+	g.connsMu.Lock()
+	if c.conn == nil {
+		delete(g.streams, c)
+	} else if g.conns[c.conn] == c {
+		delete(g.conns, c.conn)
+	}
+	g.connsMu.Unlock()
+	c.close(websocket.CloseNormalClosure, "")
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	slog.Info(fmt.Sprintf("[unregister] - client '%s' removed", c.addr))
+	g.do(context.Background(), func() error {
+		delete(g.gs.Viewers, c.id)
+		g.broadcastState("[unregister]")
+		return nil
+	})
+}
+
+// HandleWebsocketDisconnect implements GameManager.
+func (g *gameManager) HandleWebsocketDisconnect(conn *websocket.Conn) {
+	g.connsMu.RLock()
+	c, ok := g.conns[conn]
+	g.connsMu.RUnlock()
+	if ok {
+		c.close(websocket.CloseNormalClosure, "")
+	}
+}
@@ -0,0 +1,67 @@
+package loi
+
+import "fmt"
+
+type ErrorCode string
+
+const (
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeInvalidContent       ErrorCode = "invalidContent"
+	CodeCooldownActive       ErrorCode = "cooldownActive"
+	CodeTimerPaused          ErrorCode = "timerPaused"
+	CodeTimerNotPaused       ErrorCode = "timerNotPaused"
+	CodeRerollsExhausted     ErrorCode = "rerollsExhausted"
+	CodeReadyCheckInProgress ErrorCode = "readyCheckInProgress"
+	CodeNoReadyCheck         ErrorCode = "noReadyCheck"
+	CodeNotInReadyCheck      ErrorCode = "notInReadyCheck"
+	CodeGameInProgress       ErrorCode = "gameInProgress"
+	CodeNoGameInProgress     ErrorCode = "noGameInProgress"
+	CodeNoPlayers            ErrorCode = "noPlayers"
+	CodePlayerNotAvailable   ErrorCode = "playerNotAvailable"
+	CodeNoLeagueVersion      ErrorCode = "noLeagueVersion"
+	CodeEmptyChampionPool    ErrorCode = "emptyChampionPool"
+	CodeLobbyNotFound        ErrorCode = "lobbyNotFound"
+	CodeChampionNotFound     ErrorCode = "championNotFound"
+	CodeNotInGame            ErrorCode = "notInGame"
+	CodeDiscordUnavailable   ErrorCode = "discordUnavailable"
+	CodeChannelNotFound      ErrorCode = "channelNotFound"
+	CodeMissingPermission    ErrorCode = "missingPermission"
+	CodeRosterReadOnly       ErrorCode = "rosterReadOnly"
+	CodeInternal             ErrorCode = "internal"
+)
+
+// CommandError is returned by the game commands when the command can't be
+// applied, the code is stable and meant to be read by the clients.
+type CommandError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+var (
+	ErrForbidden            = &CommandError{Code: CodeForbidden, Message: "not allowed to change the game state"}
+	ErrInvalidContent       = &CommandError{Code: CodeInvalidContent, Message: "invalid command content"}
+	ErrCooldownActive       = &CommandError{Code: CodeCooldownActive, Message: "roll cooldown is active"}
+	ErrTimerPaused          = &CommandError{Code: CodeTimerPaused, Message: "roll cooldown is paused"}
+	ErrTimerNotPaused       = &CommandError{Code: CodeTimerNotPaused, Message: "roll cooldown is not paused"}
+	ErrRerollsExhausted     = &CommandError{Code: CodeRerollsExhausted, Message: "no rerolls left for the current game"}
+	ErrReadyCheckInProgress = &CommandError{Code: CodeReadyCheckInProgress, Message: "a ready check is in progress"}
+	ErrNoReadyCheck         = &CommandError{Code: CodeNoReadyCheck, Message: "no ready check in progress"}
+	ErrNotInReadyCheck      = &CommandError{Code: CodeNotInReadyCheck, Message: "player is not part of the ready check"}
+	ErrGameInProgress       = &CommandError{Code: CodeGameInProgress, Message: "game is in progress"}
+	ErrNoGameInProgress     = &CommandError{Code: CodeNoGameInProgress, Message: "no game in progress"}
+	ErrNoPlayers            = &CommandError{Code: CodeNoPlayers, Message: "no players in the slots"}
+	ErrPlayerNotAvailable   = &CommandError{Code: CodePlayerNotAvailable, Message: "player is not in the voice channel"}
+	ErrNoLeagueVersion      = &CommandError{Code: CodeNoLeagueVersion, Message: "league of legends version is unknown"}
+	ErrEmptyChampionPool    = &CommandError{Code: CodeEmptyChampionPool, Message: "no champion to select from"}
+	ErrLobbyNotFound        = &CommandError{Code: CodeLobbyNotFound, Message: "lobby not found"}
+	ErrChampionNotFound     = &CommandError{Code: CodeChampionNotFound, Message: "champion not found"}
+	ErrNotInGame            = &CommandError{Code: CodeNotInGame, Message: "player is not in the game"}
+	ErrDiscordUnavailable   = &CommandError{Code: CodeDiscordUnavailable, Message: "discord guild or voice channel is not available"}
+	ErrChannelNotFound      = &CommandError{Code: CodeChannelNotFound, Message: "voice channel not found"}
+	ErrMissingPermission    = &CommandError{Code: CodeMissingPermission, Message: "the bot is missing a discord permission"}
+	ErrRosterReadOnly       = &CommandError{Code: CodeRosterReadOnly, Message: "the players come from discord and can't be added by hand"}
+)
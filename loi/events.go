@@ -0,0 +1,109 @@
+package loi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+)
+
+// lobbyError writes the command error of a read endpoint.
+func lobbyError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrLobbyNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// HandleState implements GameManager.
+func (g *gameManager) HandleState(w http.ResponseWriter, r *http.Request, lobbyID string) {
+	var m modelwebsocket.Message
+	err := g.do(r.Context(), func() error {
+		if lobbyID != g.lobbyID() {
+			return ErrLobbyNotFound
+		}
+		g.broadcastState("[HandleState]")
+		var err error
+		m, err = g.snapshot()
+		return err
+	})
+	if err != nil {
+		lobbyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(m.Payload)
+}
+
+// HandleEvents implements GameManager. The stream is a client without
+// websocket, it receives the full state on every change like the websocket
+// clients without patches.
+func (g *gameManager) HandleEvents(w http.ResponseWriter, r *http.Request, lobbyID string) {
+	rc := http.NewResponseController(w)
+	c := newClient(nil, actorFromRequest(r).PlayerID, false, nil)
+	c.addr = r.RemoteAddr
+	err := g.do(r.Context(), func() error {
+		if lobbyID != g.lobbyID() {
+			return ErrLobbyNotFound
+		}
+		g.register(c)
+		m, err := g.snapshot()
+		if err != nil {
+			return err
+		}
+		c.enqueue(m)
+		return nil
+	})
+	if err != nil {
+		lobbyError(w, err)
+		return
+	}
+	slog.Info(fmt.Sprintf("[HandleEvents] - streaming lobby '%s' to '%s'", lobbyID, c.addr))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	g.streamLoop(r.Context(), c, w, rc)
+}
+
+// streamLoop is the writer of an event stream, it stops when the request is
+// done or a write fails and removes the client.
+func (g *gameManager) streamLoop(ctx context.Context, c *client, w http.ResponseWriter, rc *http.ResponseController) {
+	defer g.unregister(c)
+	ping := time.NewTicker(g.pingInterval)
+	defer ping.Stop()
+	write := func(event string) error {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprint(w, event); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		case <-ping.C:
+			err = write(": ping\n\n")
+		case m := <-c.send:
+			var data []byte
+			data, err = json.Marshal(m)
+			if err == nil {
+				err = write(fmt.Sprintf("event: %s\ndata: %s\n\n", m.Action, data))
+			}
+		}
+		if err != nil {
+			slog.Warn(fmt.Sprintf("[streamLoop] - failed to write to client '%s' : %s", c.addr, err.Error()))
+			return
+		}
+	}
+}
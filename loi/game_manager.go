@@ -2,8 +2,6 @@ package loi
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand"
@@ -18,62 +16,252 @@ import (
 	"github.com/phturb/bonjack-tools-backend-go/loi/model"
 	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
 	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/roster"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
+// GameManager owns the lobby game state. The commands can be called from any
+// transport, the websocket handlers are only adapters over them.
 type GameManager interface {
+	State() model.GameState
+	UpdatePlayers(ctx context.Context, actor Actor, slots []model.PlayerSlot) error
+	Roll(ctx context.Context, actor Actor, opts RollOptions) (*RollResult, error)
+	Cancel(ctx context.Context, actor Actor) error
+	Reset(ctx context.Context, actor Actor) error
+	RefreshDiscord(ctx context.Context, actor Actor) error
+	// VoiceChannels lists the voice channels of the guild the lobby can
+	// track.
+	VoiceChannels(ctx context.Context) ([]model.VoiceChannel, error)
+	// SwitchVoiceChannel makes the lobby track another voice channel and
+	// reloads its members.
+	SwitchVoiceChannel(ctx context.Context, actor Actor, channelID string) error
+	// AddRosterMember adds a player by hand when the roster is not filled by
+	// discord, the id is made from the name when it is empty.
+	AddRosterMember(ctx context.Context, actor Actor, id string, name string) error
+	RemoveRosterMember(ctx context.Context, actor Actor, id string) error
+	// AddGuest adds a player without a discord account to the lobby.
+	AddGuest(ctx context.Context, actor Actor, name string) (*model.AvailablePlayer, error)
+	RemoveGuest(ctx context.Context, actor Actor, id string) error
+	// MergeGuest moves the champion pool and the games of a guest to a
+	// discord player.
+	MergeGuest(ctx context.Context, actor Actor, guestID string, playerID string) error
+	TransferHost(ctx context.Context, actor Actor, playerID string) error
+	UpdateSettings(ctx context.Context, actor Actor, settings model.GameSettings) error
+	StartReadyCheck(ctx context.Context, actor Actor) error
+	Ready(ctx context.Context, actor Actor) error
+	PauseTimer(ctx context.Context, actor Actor) error
+	ResumeTimer(ctx context.Context, actor Actor) error
+	// LockPick locks or unlocks the pick of the actor and returns whether it
+	// is now locked.
+	LockPick(ctx context.Context, actor Actor) (bool, error)
+	AddToPool(ctx context.Context, actor Actor, champion string) (*model.Champion, error)
+	RemoveFromPool(ctx context.Context, actor Actor, champion string) (*model.Champion, error)
+	AddToGuestPool(ctx context.Context, actor Actor, guestID string, champion string) (*model.Champion, error)
+	RemoveFromGuestPool(ctx context.Context, actor Actor, guestID string, champion string) (*model.Champion, error)
+	Stats(ctx context.Context, playerID string) (*PlayerStats, error)
+
 	HandleWebsocketMessage(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) bool
-	HandleWebsocketConnection(conn *websocket.Conn, r *http.Request)
+	// HandleWebsocketConnection answers the client hello and registers the
+	// connection, an error is returned when the client is rejected.
+	HandleWebsocketConnection(conn *websocket.Conn, r *http.Request, hello *modelwebsocket.Message) error
+	// HandleWebsocketDisconnect removes a connection once it is closed.
+	HandleWebsocketDisconnect(conn *websocket.Conn)
+	// SendWebsocketError reports a message that could not be handled to the
+	// connection that sent it.
+	SendWebsocketError(conn *websocket.Conn, wm *modelwebsocket.Message, code string, message string)
+
+	// HandleEvents streams the updateState messages of the lobby as
+	// server-sent events until the request is done.
+	HandleEvents(w http.ResponseWriter, r *http.Request, lobbyID string)
+	// HandleState writes the current state of the lobby.
+	HandleState(w http.ResponseWriter, r *http.Request, lobbyID string)
+}
+
+// Actor is the player issuing a command, an actor without a player id is an
+// anonymous spectator.
+type Actor struct {
+	PlayerID string
+	Source   string
+}
+
+type RollOptions struct {
+	// RerollOnly refuses to start a new game when none is in progress.
+	RerollOnly bool
+}
+
+type RollResult struct {
+	GameID    uint
+	RollCount uint
+	Players   []model.GamePlayer
 }
 
 type gameManager struct {
 	d internal.Dependencies
 
+	// dm is nil when no discord bot is configured.
 	dm discord.DiscordManager
+	// roster tells who is in the lobby, its events are handled by the
+	// RosterSet, MemberJoined, MemberUpdated and MemberLeft methods.
+	roster roster.Provider
 
 	connsMu sync.RWMutex
-	conns   []*websocket.Conn
-
-	gsMu sync.RWMutex
+	conns   map[*websocket.Conn]*client
+	streams map[*client]bool
+	// pingInterval is the time between two pings of a client, a client that
+	// does not answer within two intervals is disconnected.
+	pingInterval time.Duration
+	idleTimeout  time.Duration
+
+	// limiters holds the rate limit of each player, shared by all of its
+	// connections. handlers bounds the websocket commands running at once.
+	limitersMu sync.Mutex
+	limiters   map[string]*limiter
+	rateLimit  float64
+	rateBurst  int
+	handlers   chan struct{}
+
+	// gs is owned by the run goroutine, every read and write of the game
+	// state goes through do so commands and discord events are applied one
+	// after the other.
 	gs   *model.GameState
+	cmds chan command
+
+	// seq is the sequence number of the last state sent to the clients and
+	// sent its content, the patches are computed against it. Both are owned
+	// by the run goroutine.
+	seq  uint64
+	sent model.GameState
+
+	// channelID is the tracked voice channel, the configured one until
+	// another is chosen. It is owned by the run goroutine.
+	channelID string
+	// teamChannels are the voice channels the players were moved in, they
+	// are part of the lobby until the players are moved back. It is owned by
+	// the run goroutine.
+	teamChannels map[string]bool
+
+	// teamMoves are applied by the moveTeams goroutine.
+	teamMoves *jobQueue[teamMove]
+	// laneRoleUpdates are applied by the applyLaneRoles goroutine.
+	laneRoleUpdates *jobQueue[laneRoleUpdate]
+	// championNicknames are the nicknames given by the applyLaneRoles
+	// goroutine by member id, discord sends them back as member updates.
+	nicknamesMu       sync.Mutex
+	championNicknames map[string]string
+
+	// rollMessages are posted to discord by the postRollMessages goroutine so
+	// the game state loop never waits for discord.
+	rollMessages *jobQueue[rollMessage]
+}
+
+type command struct {
+	fn   func() error
+	done chan error
 }
 
 var _ GameManager = (*gameManager)(nil)
 
-func NewGameManager(d internal.Dependencies, dm discord.DiscordManager) GameManager {
-	gs := model.NewDefaultGameState()
+func NewGameManager(d internal.Dependencies, dm discord.DiscordManager, rp roster.Provider) GameManager {
+	gs := model.NewDefaultGameState(model.NewDefaultGameSettings(internal.Config().GameManager.TimerTime))
+	gs.PlayersCanControl = internal.Config().GameManager.PlayersCanControl
 	gm := &gameManager{
 		d:       d,
 		dm:      dm,
+		roster:  rp,
 		connsMu: sync.RWMutex{},
-		conns:   []*websocket.Conn{},
-		gsMu:    sync.RWMutex{},
+		conns:   map[*websocket.Conn]*client{},
+		streams: map[*client]bool{},
 		gs:      &gs,
-	}
-	dm.Session().AddHandler(gm.onDiscordReady)
-	dm.Session().AddHandler(gm.onGuildCreate)
-	dm.Session().AddHandler(gm.onGuildUpdate)
-	dm.Session().AddHandler(gm.onDiscordVoiceStateUpdate)
-	dm.Session().AddHandler(gm.onChannelUpdate)
+		cmds:    make(chan command),
+		sent:    gs.Clone(),
+
+		pingInterval: time.Duration(internal.Config().Server.PingInterval) * time.Millisecond,
+		idleTimeout:  time.Duration(internal.Config().Server.IdleTimeout) * time.Millisecond,
+
+		limiters:  map[string]*limiter{},
+		rateLimit: float64(internal.Config().Server.RateLimit),
+		rateBurst: int(internal.Config().Server.RateBurst),
+		handlers:  make(chan struct{}, maxConcurrentHandlers),
+
+		rollMessages: newJobQueue("roll messages", supersedesRollMessage),
+		teamMoves:    newJobQueue("team moves", func(m teamMove, w teamMove) bool { return m.back || !w.back }),
+
+		laneRoleUpdates:   newJobQueue("lane role updates", func(u laneRoleUpdate, w laneRoleUpdate) bool { return u.clear || !w.clear }),
+		championNicknames: map[string]string{},
+	}
+	gm.channelID = gm.loadChannelID(context.Background())
+	// the game runs without discord when no bot is configured, the players
+	// then come from the roster only
+	if dm != nil {
+		dm.Session().AddHandler(gm.onDiscordReady)
+		dm.Session().AddHandler(gm.onGuildCreate)
+		dm.Session().AddHandler(gm.onGuildUpdate)
+		dm.Session().AddHandler(gm.onChannelUpdate)
+	}
+	// the run goroutine is not started yet, the lobby can be set here
+	gm.updateLobby()
+	rp.Subscribe(gm)
+
+	go gm.run()
+	go gm.postRollMessages()
+	go gm.moveTeams()
+	go gm.applyLaneRoles()
+	return gm
+}
 
+// run applies the commands in the order they are received and counts down
+// the timers, it is the only goroutine touching the game state.
+func (g *gameManager) run() {
 	ticker := time.NewTicker(1 * time.Second)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				func() {
-					gm.gsMu.Lock()
-					defer gm.gsMu.Unlock()
-					gm.gs.NextRollTimer = gm.gs.NextRollTimer - 1000
-					if gm.gs.NextRollTimer < 0 {
-						gm.gs.NextRollTimer = 0
-					}
-				}()
-			}
+	defer ticker.Stop()
+	for {
+		select {
+		case c := <-g.cmds:
+			c.done <- c.fn()
+		case <-ticker.C:
+			g.tick()
+			g.pruneLimiters()
 		}
-	}()
-	return gm
+	}
+}
+
+// do runs fn on the game state goroutine and waits for its result. It must
+// not be called from the game state goroutine itself.
+func (g *gameManager) do(ctx context.Context, fn func() error) error {
+	c := command{
+		fn:   fn,
+		done: make(chan error, 1),
+	}
+	select {
+	case g.cmds <- c:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-c.done
+}
+
+// tick must be called from the game state loop.
+func (g *gameManager) tick() {
+	g.tickReadyCheck()
+	if g.gs.TimerPaused || g.gs.NextRollTimer == 0 {
+		return
+	}
+	if g.gs.NextRollTimer > 1000 {
+		g.gs.NextRollTimer = g.gs.NextRollTimer - 1000
+		return
+	}
+	g.gs.NextRollTimer = 0
+	if !g.gs.GameInProgress || g.rerollsExhausted() {
+		return
+	}
+	slog.Info("[tick] - roll cooldown is over")
+	g.gs.CanRoll = true
+	g.broadcastState("[tick]")
+}
+
+// rerollsExhausted must be called from the game state loop.
+func (g *gameManager) rerollsExhausted() bool {
+	return g.gs.Settings.MaxRerolls > 0 && g.gs.RollCount > g.gs.Settings.MaxRerolls
 }
 
 func (g *gameManager) onDiscordReady(s *discordgo.Session, e *discordgo.Ready) {
@@ -93,8 +281,17 @@ func (g *gameManager) onDiscordReady(s *discordgo.Session, e *discordgo.Ready) {
 		panic("[onDiscordReady] - onDiscordReady - no guild found")
 	}
 	slog.Info(fmt.Sprintf("[onDiscordReady] - configured guild found %s (%s)", guild.Name, guild.ID))
-	g.gs.DiscordGuildID = guild.ID
-	g.gs.DiscordGuildName = guild.Name
+	g.do(context.Background(), func() error {
+		g.gs.DiscordGuildID = guild.ID
+		g.gs.DiscordGuildName = guild.Name
+		// the lane roles and the team moves left by a previous run are
+		// undone once the guild is known
+		if !g.gs.GameInProgress {
+			g.queueLaneRoles(true)
+			g.queueTeamMove(true)
+		}
+		return nil
+	})
 }
 
 func (g *gameManager) onChannelUpdate(s *discordgo.Session, e *discordgo.ChannelUpdate) {
@@ -107,36 +304,39 @@ func (g *gameManager) onGuildUpdate(s *discordgo.Session, e *discordgo.GuildUpda
 		slog.Error("[onGuildUpdate] - no guild found")
 		return
 	}
-	if e.Guild.ID != g.gs.DiscordGuildID {
-		slog.Info("[onGuildUpdate] - skipping onGuildUpdate event, guild id mismatch")
-		return
+	g.do(context.Background(), func() error {
+		if !g.configureGuildChannel("[onGuildUpdate]", e.Guild) {
+			return nil
+		}
+		g.broadcastState("[onGuildUpdate]")
+		return nil
+	})
+}
+
+// configureGuildChannel sets the configured voice channel from the guild
+// channels, it returns false when the event is for another guild. It must be
+// called from the game state loop.
+func (g *gameManager) configureGuildChannel(prefix string, guild *discordgo.Guild) bool {
+	if guild.ID != g.gs.DiscordGuildID {
+		slog.Info(fmt.Sprintf("%s - skipping event, guild id mismatch", prefix))
+		return false
 	}
-	slog.Info("[onGuildUpdate] - guild id match, populating initial information")
+	slog.Info(fmt.Sprintf("%s - guild id match, populating initial information", prefix))
 	channelFound := false
-	for _, c := range e.Channels {
-		if c.ID != internal.Config().Discord.ChannelID {
+	for _, c := range guild.Channels {
+		if c.ID != g.channelID {
 			continue
 		}
-		slog.Info(fmt.Sprintf("[onGuildUpdate] - configuring channel id %s with name %s", c.ID, c.Name))
+		slog.Info(fmt.Sprintf("%s - configuring channel id %s with name %s", prefix, c.ID, c.Name))
 		g.gs.DiscordGuildChannelName = c.Name
 		g.gs.DiscordGuildChannelID = c.ID
 		channelFound = true
 		break
 	}
 	if !channelFound {
-		slog.Error("[onGuildUpdate] - failed to find channel in config")
-	}
-
-	sgs, err := json.Marshal(*g.gs)
-	if err != nil {
-		slog.Error(fmt.Sprintf("[onGuildUpdate] - failed to serialized game state : %s", err.Error()))
-		return
+		slog.Error(fmt.Sprintf("%s - failed to find channel in config", prefix))
 	}
-	m := modelwebsocket.Message{
-		Action:  modelwebsocket.UpdateState,
-		Content: string(sgs),
-	}
-	g.broadcast(m, nil)
+	return true
 }
 
 func (g *gameManager) onGuildCreate(s *discordgo.Session, e *discordgo.GuildCreate) {
@@ -145,365 +345,166 @@ func (g *gameManager) onGuildCreate(s *discordgo.Session, e *discordgo.GuildCrea
 		slog.Error("[onGuildCreate] - no guild found")
 		return
 	}
-	if e.Guild.ID != g.gs.DiscordGuildID {
-		slog.Info("[onGuildCreate] - skipping onGuildCreate event, guild id mismatch")
-		return
-	}
-	slog.Info("[onGuildCreate] - guild id match, populating initial information")
-	channelFound := false
-	for _, c := range e.Channels {
-		if c.ID != internal.Config().Discord.ChannelID {
-			continue
-		}
-		slog.Info(fmt.Sprintf("[onGuildCreate] - configuring channel id %s with name %s", c.ID, c.Name))
-		g.gs.DiscordGuildChannelName = c.Name
-		g.gs.DiscordGuildChannelID = c.ID
-		channelFound = true
-		break
-	}
-	if !channelFound {
-		slog.Error("[onGuildCreate] - failed to find channel in config")
-	}
-	slog.Info("[onGuildCreate] - looking for members already in the voice channel")
-	inChannelUserID := map[string]*discordgo.Member{}
-	for _, vs := range e.VoiceStates {
-		if vs.ChannelID != g.gs.DiscordGuildChannelID {
-			continue
-		}
-		inChannelUserID[vs.UserID] = &discordgo.Member{}
-		slog.Info(fmt.Sprintf("[onGuildCreate] - found user id '%s' in voice channel", vs.UserID))
-	}
-	if len(inChannelUserID) == 0 {
-		slog.Warn("[onGuildCreate] - no members found in voice channel")
-	}
-	for _, m := range e.Members {
-		if _, ok := inChannelUserID[m.User.ID]; ok {
-			inChannelUserID[m.User.ID] = m
+	g.do(context.Background(), func() error {
+		if !g.configureGuildChannel("[onGuildCreate]", e.Guild) {
+			return nil
 		}
-	}
-	members := make([]*discordgo.Member, 0, len(inChannelUserID))
-	for _, m := range inChannelUserID {
-		members = append(members, m)
-	}
-	g.setPlayersFromMembers(members)
+		g.broadcastState("[onGuildCreate]")
+		return nil
+	})
 }
 
-func (g *gameManager) setPlayersFromMembers(members []*discordgo.Member) {
-	ctx := context.Background()
-	g.gsMu.Lock()
-	defer g.gsMu.Unlock()
-	aps := make(map[string]model.AvailablePlayer)
-	for _, m := range members {
-		var ap model.AvailablePlayer
-		if m == nil {
-			slog.Warn("discord api returned empty members for discord channel")
-			continue
-		}
-		if m.User == nil || m.User.ID == "" {
-			slog.Warn("member is missing user, can't identify user id")
-			continue
+// State implements GameManager.
+func (g *gameManager) State() model.GameState {
+	var gs model.GameState
+	g.do(context.Background(), func() error {
+		gs = g.gs.Clone()
+		return nil
+	})
+	return gs
+}
+
+// UpdatePlayers implements GameManager.
+func (g *gameManager) UpdatePlayers(ctx context.Context, actor Actor, slots []model.PlayerSlot) error {
+	return g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
 		}
-		name := m.Nick
-		if name == "" {
-			slog.Warn(fmt.Sprintf("user id '%s' is missing nick name, using display name '%s'", m.User.ID, m.Nick))
-			name = m.DisplayName()
-		}
-		ap = model.AvailablePlayer{
-			ID:   &m.User.ID,
-			Name: &name,
-		}
-		aps[*ap.ID] = ap
-		if err := g.d.Database(ctx).Model(&sharedmodel.Player{}).Clauses(clause.OnConflict{
-			UpdateAll: true,
-		}).Create(&sharedmodel.Player{
-			ID:   *ap.ID,
-			Name: ap.Name,
-		}).Error; err != nil {
-			slog.Warn("failed to update player in database : " + err.Error())
-			continue
+		if g.gs.GameInProgress {
+			return ErrGameInProgress
 		}
-	}
+		slog.Warn("[UpdatePlayers] - game is not in progress updating players list")
 
-	g.gs.AvailablePlayers = aps
-	if !g.gs.GameInProgress {
-		for i, p := range g.gs.Players {
-			if p.Player.ID == "" {
-				continue
+		var gps []model.GamePlayer
+		naps := map[string]model.PlayerSlot{}
+		j := 0
+		for _, c := range slots {
+			if c.ID != "" {
+				if _, ok := naps[c.ID]; ok {
+					slog.Warn(fmt.Sprintf("[UpdatePlayers] - double entry for player %s, dropping entry", c.ID))
+					continue
+				}
 			}
-			if ap, ok := aps[p.Player.ID]; !ok {
-				slog.Warn(fmt.Sprintf("player not found '%s' in game state", p.Player.ID))
-				g.gs.Players[i] = model.NewEmptyGamePlayer()
+			j++
+			naps[c.ID] = c
+			if ap, ok := g.gs.AvailablePlayers[c.ID]; ok {
+				gps = append(gps, model.GamePlayer{
+					Player: slotPlayer(ap),
+				})
 			} else {
-				g.gs.Players[i].Player.ID = *ap.ID
-				g.gs.Players[i].Player.Name = ap.Name
+				gps = append(gps, model.NewEmptyGamePlayer())
+			}
+			if j >= g.gs.Settings.SlotCount() {
+				slog.Warn(fmt.Sprintf("[UpdatePlayers] dropping any players going beyond %d", g.gs.Settings.SlotCount()))
+				break
 			}
 		}
-		for len(g.gs.Players) < 5 {
-			g.gs.Players = append(g.gs.Players, model.NewEmptyGamePlayer())
+		for len(gps) < g.gs.Settings.SlotCount() {
+			slog.Warn("[UpdatePlayers] missing player, adding empty one")
+			gps = append(gps, model.NewEmptyGamePlayer())
 		}
-	}
+		g.gs.Players = gps
+		g.electHost()
 
-	sgs, err := json.Marshal(*g.gs)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to serialized game state : %s", err.Error()))
-		return
-	}
-	m := modelwebsocket.Message{
-		Action:  modelwebsocket.UpdateState,
-		Content: string(sgs),
-	}
-	g.broadcast(m, nil)
+		g.broadcastState("[UpdatePlayers]")
+		return nil
+	})
 }
 
-func (g *gameManager) onDiscordVoiceStateUpdate(s *discordgo.Session, u *discordgo.VoiceStateUpdate) {
-	slog.Info("[onDiscordVoiceStateUpdate] - event received")
-	g.gsMu.Lock()
-	defer g.gsMu.Unlock()
-	if u.ChannelID != g.gs.DiscordGuildChannelID {
-		slog.Warn(fmt.Sprintf("[onDiscordVoiceStateUpdate] - channel ID is not the supported one '%s', assuming player quit the channel", u.ChannelID))
-		if !g.gs.GameInProgress {
-			for i, p := range g.gs.Players {
-				if p.Player.ID == u.Member.User.ID {
-					g.gs.Players[i] = model.NewEmptyGamePlayer()
-					slog.Warn(fmt.Sprintf("[onDiscordVoiceStateUpdate] - removing player id %s from game state", p.Player.ID))
-				}
-			}
+// Roll implements GameManager.
+func (g *gameManager) Roll(ctx context.Context, actor Actor, opts RollOptions) (*RollResult, error) {
+	var res *RollResult
+	err := g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
 		}
-		slog.Warn(fmt.Sprintf("[onDiscordVoiceStateUpdate] - removing player with id %s and name %s from available players", u.Member.User.ID, u.Member.DisplayName()))
-		delete(g.gs.AvailablePlayers, u.Member.User.ID)
-	} else {
-		displayName := u.Member.DisplayName()
-		slog.Warn(fmt.Sprintf("[onDiscordVoiceStateUpdate] - adding player with id %s and name %s to available players", u.Member.User.ID, displayName))
-		g.gs.AvailablePlayers[u.Member.User.ID] = model.AvailablePlayer{
-			ID:   &u.Member.User.ID,
-			Name: &displayName,
+		if opts.RerollOnly && !g.gs.GameInProgress {
+			return ErrNoGameInProgress
 		}
-		if !g.gs.GameInProgress {
-			for i := range g.gs.Players {
-				if g.gs.Players[i].Player.ID == "" {
-					slog.Warn(fmt.Sprintf("[onDiscordVoiceStateUpdate] - adding player with id %s and name %s to current players slot %d", u.Member.User.ID, displayName, i))
-					g.gs.Players[i].Player = model.DiscordPlayer{
-						ID:   u.Member.User.ID,
-						Name: &displayName,
-					}
-					break
-				}
-			}
+		if g.gs.ReadyCheck.Active {
+			return ErrReadyCheckInProgress
 		}
-	}
-
-	sgs, err := json.Marshal(*g.gs)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to serialized game state : %s", err.Error()))
-		return
-	}
-	m := modelwebsocket.Message{
-		Action:  modelwebsocket.UpdateState,
-		Content: string(sgs),
-	}
-	g.broadcast(m, nil)
+		var err error
+		res, err = g.roll(ctx)
+		return err
+	})
+	return res, err
 }
 
-// HandleWebsocketConnection implements GameManager.
-func (g *gameManager) HandleWebsocketConnection(conn *websocket.Conn, r *http.Request) {
-	slog.Info("[HandleWebsocketConnection] - handling websocket connection")
-	g.gsMu.RLock()
-	defer g.gsMu.RUnlock()
-	sgs, err := json.Marshal(*g.gs)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to serialized game state : %s", err.Error()))
-		return
+// roll selects the roles and champions of every player in the slots and
+// saves the roll, the game state is only changed once the roll is saved. It
+// must be called from the game state loop.
+func (g *gameManager) roll(ctx context.Context) (*RollResult, error) {
+	if g.gs.TimerPaused {
+		return nil, ErrTimerPaused
 	}
-	m := modelwebsocket.Message{
-		Action:  modelwebsocket.UpdateState,
-		Content: string(sgs),
+	if g.gs.GameInProgress && g.rerollsExhausted() {
+		return nil, ErrRerollsExhausted
 	}
-	g.connsMu.Lock()
-	defer g.connsMu.Unlock()
-	g.conns = append(g.conns, conn)
-	slog.Info("[HandleWebsocketConnection] - sending game state to the new connection")
-	if err := conn.WriteJSON(m); err != nil {
-		slog.Error(err.Error())
-	}
-}
-
-func (g *gameManager) broadcast(m interface{}, sender *websocket.Conn) error {
-	slog.Info("[broadcast] - broadcasting message")
-	g.connsMu.RLock()
-	defer g.connsMu.RUnlock()
-	var errs []error
-	for _, c := range g.conns {
-		if c == sender {
-			continue
-		}
-		if err := c.WriteJSON(m); err != nil {
-			errs = append(errs, err)
-		}
+	if !g.gs.CanRoll {
+		return nil, ErrCooldownActive
 	}
-	return errors.Join(errs...)
-}
-
-// HandleWebsocket implements GameManager.
-func (g *gameManager) HandleWebsocketMessage(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) bool {
-	slog.Info(fmt.Sprintf("[HandleWebsocketMessage] - %s event received", wm.Action))
-	switch wm.Action {
-	case modelwebsocket.UpdatePlayers:
-		go g.handleUpdatePlayers(wm, conn, r)
-		return true
-	case modelwebsocket.Roll:
-		go g.handleRoll(wm, conn, r)
-		return true
-	case modelwebsocket.Cancel:
-		go g.handleCancel(wm, conn, r)
-		return true
-	case modelwebsocket.Reset:
-		go g.handleReset(wm, conn, r)
-		return true
-	case modelwebsocket.RefreshDiscord:
-		go g.handleRefreshDiscord(wm, conn, r)
-		return true
-	default:
-		slog.Debug(fmt.Sprintf("websocket action '%s' is not handled by the game manager", wm.Action))
-		return false
+	if !g.hasPlayers() {
+		return nil, ErrNoPlayers
 	}
-}
-
-func (g *gameManager) handleUpdatePlayers(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) {
-	g.gsMu.Lock()
-	defer g.gsMu.Unlock()
-	if g.gs.GameInProgress {
-		slog.Warn("[handleUpdatePlayers] - game is in progress, skipping update")
-		sgs, err := json.Marshal(*g.gs)
-		if err != nil {
-			slog.Error(fmt.Sprintf("[handleUpdatePlayers] - failed to marshal game state : %s", err.Error()))
-			return
-		}
-		m := modelwebsocket.Message{
-			Action:  modelwebsocket.UpdateState,
-			Content: string(sgs),
-		}
-		conn.WriteJSON(m)
-		return
+	db := g.d.Database(ctx)
+	var lVer sharedmodel.LeagueVersion
+	if err := db.First(&lVer).Error; err != nil {
+		return nil, fmt.Errorf("%w : %s", ErrNoLeagueVersion, err.Error())
 	}
-	slog.Warn("[handleUpdatePlayers] - game is not in progress updating players list")
 
-	type content struct {
-		ID   string  `json:"id"`
-		Name *string `json:"name,omitempty"`
-	}
-	var cs []content
-	if err := json.Unmarshal([]byte(wm.Content), &cs); err != nil {
-		slog.Error(err.Error())
-		return
+	// every team has one player for each role
+	roles := make(model.Roles, 0, len(g.gs.Players)+model.TeamSize)
+	for len(roles) < len(g.gs.Players) {
+		roles = append(roles, model.NewRoleSlice().Shuffle()...)
 	}
-
-	var gps []model.GamePlayer
-	naps := map[string]content{}
-	j := 0
-	for _, c := range cs {
-		if c.ID != "" {
-			if _, ok := naps[c.ID]; ok {
-				slog.Warn(fmt.Sprintf("[handleUpdatePlayers] - double entry for player %s, dropping entry", c.ID))
-				continue
+	if g.gs.GameInProgress && g.gs.Settings.RollStrategy == model.RollStrategyKeepRoles {
+		slog.Info("[roll] - keeping the roles of the first roll")
+		for i, p := range g.gs.Players {
+			if p.Role != nil {
+				roles[i] = *p.Role
 			}
 		}
-		j++
-		naps[c.ID] = c
-		if ap, ok := g.gs.AvailablePlayers[c.ID]; ok {
-			gps = append(gps, model.GamePlayer{
-				Player: model.DiscordPlayer{
-					ID:   c.ID,
-					Name: ap.Name,
-				},
-			})
-		} else {
-			gps = append(gps, model.NewEmptyGamePlayer())
-		}
-		if j > 4 {
-			slog.Warn("[handleUpdatePlayers] dropping any players going beyond 5")
-			break
-		}
-	}
-	for len(gps) < 5 {
-		slog.Warn("[handleUpdatePlayers] missing player, adding empty one")
-		gps = append(gps, model.NewEmptyGamePlayer())
-	}
-	g.gs.Players = gps
-
-	sgs, err := json.Marshal(*g.gs)
-	if err != nil {
-		slog.Error(fmt.Sprintf("[handleUpdatePlayers] - failed to marshal game state : %s", err.Error()))
-		return
-	}
-	m := modelwebsocket.Message{
-		Action:  modelwebsocket.UpdateState,
-		Content: string(sgs),
+	} else {
+		slog.Info("[roll] - shuffling the new roles")
 	}
-	g.broadcast(m, nil)
-}
 
-func (g *gameManager) handleRoll(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) {
-	ctx := r.Context()
-	g.gsMu.Lock()
-	defer g.gsMu.Unlock()
-	if !g.gs.CanRoll {
-		slog.Info("[handleRoll] - game is not allowed to roll")
-		return
-	}
-	db := g.d.Database(r.Context())
-	var lVer sharedmodel.LeagueVersion
-	if err := db.First(&lVer).Error; err != nil {
-		return
-	}
-	g.gs.LeagueVersion = lVer.Version
-	g.gs.CanRoll = false
-	g.gs.NextRollTimer = internal.Config().GameManager.TimerTime
-	if !g.gs.GameInProgress {
-		slog.Info("[handleRoll] - game is not in progress, updating database with initial roll")
-		game := sharedmodel.Game{}
-		db.Transaction(func(tx *gorm.DB) error {
-			if err := tx.Model(&sharedmodel.Game{}).Create(&game).Error; err != nil {
-				return err
+	players := make([]model.GamePlayer, len(g.gs.Players))
+	copy(players, g.gs.Players)
+	rcs := make([]sharedmodel.Champion, 0, len(players))
+	if g.gs.GameInProgress {
+		// the locked picks are kept before rolling the others so their
+		// roles and champions are not given to another player
+		for i, p := range players {
+			if !p.Locked || p.Role == nil || p.Champion == nil {
+				continue
 			}
-			gps := make([]sharedmodel.GamePlayer, 0)
-			for _, p := range g.gs.Players {
-				if p.Player.ID == "" {
-					continue
+			team := model.TeamOf(i) * model.TeamSize
+			for j := team; j < team+model.TeamSize; j++ {
+				if roles[j] == *p.Role {
+					roles[i], roles[j] = roles[j], roles[i]
+					break
 				}
-				gps = append(gps, sharedmodel.GamePlayer{
-					GameID:   game.ID,
-					PlayerID: p.Player.ID,
-				})
 			}
-
-			if err := tx.Model(&sharedmodel.GamePlayer{}).Create(gps).Error; err != nil {
-				return err
-			}
-			return nil
-		})
-		g.gs.GameId = game.ID
-		slog.Info("loi des norms has started")
-	}
-	g.gs.RollCount += 1
-	slog.Info(fmt.Sprintf("[handleRoll] - incrementing roll count to %d", g.gs.RollCount))
-
-	gprs := make([]sharedmodel.GamePlayerRoll, 0)
-	rcs := make([]sharedmodel.Champion, 0, 5)
-	slog.Info("[handleRoll] - shuffling the new roles")
-	roles := model.NewRoleSlice().Shuffle()
-	for i, p := range g.gs.Players {
-		slog.Info(fmt.Sprintf("[handleRoll] - assigning player %s the role %s", p.Player.ID, roles[i]))
-		g.gs.Players[i].Role = &roles[i]
+			rcs = append(rcs, sharedmodel.Champion{ID: p.Champion.ID, Name: p.Champion.Name, Img: p.Champion.Img})
+		}
+	}
+	for i, p := range players {
+		if g.gs.GameInProgress && p.Locked && p.Role != nil && p.Champion != nil {
+			slog.Info(fmt.Sprintf("[roll] - keeping the locked pick of player %s", p.Player.ID))
+			continue
+		}
+		slog.Info(fmt.Sprintf("[roll] - assigning player %s the role %s", p.Player.ID, roles[i]))
+		players[i].Role = &roles[i]
 		if p.Player.ID == "" {
 			continue
 		}
-		var rc sharedmodel.Champion
-		slog.Info(fmt.Sprintf("[handleRoll] - retrieving the player %s list of champions", p.Player.ID))
-		pcs, _ := g.retrieveChampionsForPlayer(ctx, p)
-		if len(pcs) <= 0 {
-			slog.Error("no champion to select from")
-			return
+		slog.Info(fmt.Sprintf("[roll] - retrieving the player %s list of champions", p.Player.ID))
+		pcs, err := g.retrieveChampionsForPlayer(ctx, p, g.gs.Settings)
+		if err != nil {
+			return nil, err
 		}
+		var rc *sharedmodel.Champion
 		for len(pcs) > 0 {
 			ri := rand.Intn(len(pcs))
 			trc := pcs[ri]
@@ -517,47 +518,107 @@ func (g *gameManager) handleRoll(wm *modelwebsocket.Message, conn *websocket.Con
 				}
 			}
 			if !contain {
-				rc = trc
+				rc = &trc
 				break
 			}
 		}
-		slog.Info(fmt.Sprintf("[handleRoll] - assigning player %s the champion %s", p.Player.ID, rc.Name))
-		rcs = append(rcs, rc)
-		g.gs.Players[i].Champion = model.ChampionFromDB(&rc)
-		gprs = append(gprs, sharedmodel.GamePlayerRoll{
-			GameID:     g.gs.GameId,
-			PlayerID:   p.Player.ID,
-			RollNumber: g.gs.RollCount,
-			Role:       (&roles[i]).StringPtr(),
-			ChampionID: &rc.ID,
-		})
-	}
-
-	slog.Info(fmt.Sprintf("[handleRoll] - updating the database with the current rolls"))
-	if err := db.Model(&sharedmodel.GamePlayerRoll{}).Create(&gprs).Error; err != nil {
-		slog.Error("failed to update database with game player roll")
-		return
+		if rc == nil {
+			return nil, fmt.Errorf("%w : player %s", ErrEmptyChampionPool, p.Player.ID)
+		}
+		slog.Info(fmt.Sprintf("[roll] - assigning player %s the champion %s", p.Player.ID, rc.Name))
+		rcs = append(rcs, *rc)
+		players[i].Champion = model.ChampionFromDB(rc)
 	}
 
-	if !g.gs.GameInProgress {
-		slog.Info(fmt.Sprintf("[handleRoll] - loi des norms (%d) has started", g.gs.GameId))
-		g.gs.GameInProgress = true
+	gameID := g.gs.GameId
+	rollCount := g.gs.RollCount + 1
+	slog.Info(fmt.Sprintf("[roll] - updating the database with roll %d", rollCount))
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if !g.gs.GameInProgress {
+			slog.Info("[roll] - game is not in progress, updating database with initial roll")
+			game := sharedmodel.Game{
+				Settings: model.GameSettingsToDB(g.gs.Settings),
+			}
+			if err := tx.Model(&sharedmodel.Game{}).Create(&game).Error; err != nil {
+				return err
+			}
+			gameID = game.ID
+			gps := make([]sharedmodel.GamePlayer, 0)
+			for _, p := range players {
+				if p.Player.ID == "" {
+					continue
+				}
+				gps = append(gps, sharedmodel.GamePlayer{
+					GameID:   gameID,
+					PlayerID: p.Player.ID,
+				})
+			}
+			if err := tx.Model(&sharedmodel.GamePlayer{}).Create(gps).Error; err != nil {
+				return err
+			}
+		}
+		gprs := make([]sharedmodel.GamePlayerRoll, 0)
+		for _, p := range players {
+			if p.Player.ID == "" {
+				continue
+			}
+			gprs = append(gprs, sharedmodel.GamePlayerRoll{
+				GameID:     gameID,
+				PlayerID:   p.Player.ID,
+				RollNumber: rollCount,
+				Role:       p.Role.StringPtr(),
+				ChampionID: &p.Champion.ID,
+			})
+		}
+		return tx.Model(&sharedmodel.GamePlayerRoll{}).Create(&gprs).Error
+	}); err != nil {
+		slog.Error("[roll] - failed to update database with game player roll : " + err.Error())
+		return nil, err
 	}
 
-	slog.Info(fmt.Sprintf("[handleRoll] - sending the new game state to the users"))
-	sgs, err := json.Marshal(*g.gs)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to marshal game state : %s", err.Error()))
-		return
+	started := !g.gs.GameInProgress
+	if started {
+		slog.Info(fmt.Sprintf("[roll] - loi des norms (%d) has started", gameID))
 	}
-	m := modelwebsocket.Message{
-		Action:  modelwebsocket.UpdateState,
-		Content: string(sgs),
+	g.gs.LeagueVersion = lVer.Version
+	g.gs.NextRollTimer = g.gs.Settings.Cooldown
+	g.gs.GameId = gameID
+	g.gs.RollCount = rollCount
+	g.gs.Players = players
+	g.gs.GameInProgress = true
+	// the tick only allows the next roll once a cooldown is over, without
+	// cooldown the reroll is allowed right away
+	g.gs.CanRoll = g.gs.NextRollTimer == 0 && !g.gs.TimerPaused && !g.rerollsExhausted()
+
+	slog.Info("[roll] - sending the new game state to the users")
+	g.broadcastState("[roll]")
+	g.queueRollMessage(rollMessageLive)
+	if started && g.moveToTeamChannels() {
+		g.queueTeamMove(false)
+	}
+	if g.laneRolesEnabled() {
+		g.queueLaneRoles(false)
+	}
+	res := &RollResult{
+		GameID:    gameID,
+		RollCount: rollCount,
+		Players:   make([]model.GamePlayer, len(players)),
+	}
+	copy(res.Players, players)
+	return res, nil
+}
+
+// hasPlayers must be called from the game state loop.
+func (g *gameManager) hasPlayers() bool {
+	for _, p := range g.gs.Players {
+		if p.Player.ID != "" {
+			return true
+		}
 	}
-	g.broadcast(m, nil)
+	return false
 }
 
-func (g *gameManager) retrieveChampionsForPlayer(ctx context.Context, gp model.GamePlayer) ([]sharedmodel.Champion, error) {
+func (g *gameManager) retrieveChampionsForPlayer(ctx context.Context, gp model.GamePlayer, settings model.GameSettings) ([]sharedmodel.Champion, error) {
 	db := g.d.Database(ctx)
 	cs := make([]sharedmodel.Champion, 0)
 	err := g.d.Database(ctx).Transaction(func(tx *gorm.DB) error {
@@ -577,6 +638,9 @@ func (g *gameManager) retrieveChampionsForPlayer(ctx context.Context, gp model.G
 				return err
 			}
 		}
+		if !settings.IncludeWeeklyRotation {
+			return nil
+		}
 		wcs := make([]sharedmodel.WeeklyChampion, 0)
 		if err := db.Model(&sharedmodel.WeeklyChampion{}).Preload("Champion").Find(&wcs).Error; err != nil {
 			slog.Error(fmt.Sprintf("failed to retrieve weekly champions : %s", err.Error()))
@@ -599,76 +663,143 @@ func (g *gameManager) retrieveChampionsForPlayer(ctx context.Context, gp model.G
 		}
 		return nil
 	})
+	if len(settings.BannedChampionIDs) > 0 {
+		allowed := make([]sharedmodel.Champion, 0, len(cs))
+		for _, c := range cs {
+			if !settings.IsBanned(c.ID) {
+				allowed = append(allowed, c)
+			}
+		}
+		cs = allowed
+	}
 	return cs, err
 }
 
-func (g *gameManager) handleCancel(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) {
-	db := g.d.Database(r.Context())
-	if err := func() error {
-		g.gsMu.Lock()
-		defer g.gsMu.Unlock()
+// Cancel implements GameManager.
+func (g *gameManager) Cancel(ctx context.Context, actor Actor) error {
+	return g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
+		}
 		if g.gs.GameInProgress && g.gs.RollCount > 0 {
-			slog.Info(fmt.Sprintf("[handleCancel] - cancelling the current loi"))
-			err := db.Transaction(func(tx *gorm.DB) error {
+			slog.Info("[Cancel] - cancelling the current loi")
+			err := g.d.Database(ctx).Transaction(func(tx *gorm.DB) error {
 				if err := tx.Where("game_id = ?", g.gs.GameId).Delete(&sharedmodel.GamePlayerRoll{}).Error; err != nil {
 					return err
 				}
 				if err := tx.Where("game_id = ?", g.gs.GameId).Delete(&sharedmodel.GamePlayer{}).Error; err != nil {
 					return err
 				}
+				if err := tx.Where("game_id = ?", g.gs.GameId).Delete(&sharedmodel.GamePause{}).Error; err != nil {
+					return err
+				}
 				if err := tx.Where("id = ?", g.gs.GameId).Delete(&sharedmodel.Game{}).Error; err != nil {
 					return err
 				}
 				return nil
 			})
 			if err != nil {
-				slog.Error("[handleCancel] - " + err.Error())
+				slog.Error("[Cancel] - " + err.Error())
 				return err
 			}
+			g.queueRollMessage(rollMessageCancelled)
 			g.gs.GameId = 0
+			g.gs.TimerPaused = false
 		}
-		return nil
-	}(); err != nil {
-		return
-	}
-	g.handleReset(wm, conn, r)
+		return g.reset(ctx)
+	})
+}
+
+// Reset implements GameManager.
+func (g *gameManager) Reset(ctx context.Context, actor Actor) error {
+	return g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
+		}
+		return g.reset(ctx)
+	})
 }
 
-func (g *gameManager) handleReset(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) {
-	g.gsMu.Lock()
-	defer g.gsMu.Unlock()
-	db := g.d.Database(r.Context())
+// reset must be called from the game state loop.
+func (g *gameManager) reset(ctx context.Context) error {
+	db := g.d.Database(ctx)
 	var lVer sharedmodel.LeagueVersion
 	if err := db.First(&lVer).Error; err != nil {
-		return
+		return fmt.Errorf("%w : %s", ErrNoLeagueVersion, err.Error())
+	}
+	if g.gs.TimerPaused {
+		g.endPause(ctx)
+	}
+	if g.gs.GameInProgress && g.gs.GameId != 0 {
+		g.queueRollMessage(rollMessageFinished)
+	}
+	if g.gs.GameInProgress && g.moveToTeamChannels() {
+		g.queueTeamMove(true)
 	}
-	slog.Info(fmt.Sprintf("[handleReset] - resetting the game state values"))
+	if g.gs.GameInProgress && g.laneRolesEnabled() {
+		g.queueLaneRoles(true)
+	}
+	slog.Info("[reset] - resetting the game state values")
 	g.gs.LeagueVersion = lVer.Version
 	g.gs.GameInProgress = false
 	g.gs.RollCount = 0
 	g.gs.NextRollTimer = 0
 	g.gs.CanRoll = true
-	slog.Info(fmt.Sprintf("[handleReset] - removing players that are no longer available"))
+	g.gs.ReadyCheck = model.NewEmptyReadyCheck()
+	g.gs.TimerPaused = false
+	slog.Info("[reset] - removing players that are no longer available")
 	for i := range g.gs.Players {
 		if _, ok := g.gs.AvailablePlayers[g.gs.Players[i].Player.ID]; !ok {
 			g.gs.Players[i] = model.NewEmptyGamePlayer()
 		} else {
 			g.gs.Players[i].Role = nil
 			g.gs.Players[i].Champion = nil
+			g.gs.Players[i].Locked = false
 		}
 	}
 
-	sgs, err := json.Marshal(*g.gs)
-	if err != nil {
-		slog.Error(fmt.Sprintf("[handleReset] - failed to marshal game state : %s", err.Error()))
-		return
-	}
-	m := modelwebsocket.Message{
-		Action:  modelwebsocket.UpdateState,
-		Content: string(sgs),
-	}
-	g.broadcast(m, nil)
+	g.broadcastState("[reset]")
+	return nil
 }
 
-func (g *gameManager) handleRefreshDiscord(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) {
+// LockPick implements GameManager.
+func (g *gameManager) LockPick(ctx context.Context, actor Actor) (bool, error) {
+	var locked bool
+	err := g.do(ctx, func() error {
+		if !g.gs.GameInProgress {
+			return ErrNoGameInProgress
+		}
+		for i, p := range g.gs.Players {
+			if actor.PlayerID == "" || p.Player.ID != actor.PlayerID {
+				continue
+			}
+			locked = !p.Locked
+			g.gs.Players[i].Locked = locked
+			slog.Info(fmt.Sprintf("[LockPick] - player '%s' locked its pick : %t", actor.PlayerID, locked))
+			g.broadcastState("[LockPick]")
+			g.queueRollMessage(rollMessageLive)
+			return nil
+		}
+		return ErrNotInGame
+	})
+	return locked, err
+}
+
+// RefreshDiscord rebuilds the available players from the roster, for when the
+// gateway missed a voice event.
+func (g *gameManager) RefreshDiscord(ctx context.Context, actor Actor) error {
+	slog.Info(fmt.Sprintf("[RefreshDiscord] - '%s' refreshing the voice channel members", actor.PlayerID))
+	return g.refreshMembers(ctx)
+}
+
+// refreshMembers sets the available players from a snapshot of the roster, it
+// must not be called from the game state loop.
+func (g *gameManager) refreshMembers(ctx context.Context) error {
+	members, err := g.roster.Snapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("%w : %s", ErrDiscordUnavailable, err.Error())
+	}
+	slog.Info(fmt.Sprintf("[refreshMembers] - refreshing %d members of the lobby", len(members)))
+	g.RosterSet(members)
+	return nil
 }
@@ -1,18 +1,32 @@
 package loi
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/auth"
+	"github.com/phturb/bonjack-tools-backend-go/discord"
 	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
 	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
 	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/roster"
+	"github.com/phturb/bonjack-tools-backend-go/roster/rostertest"
 	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -23,6 +37,110 @@ import (
 type MockDiscordManager struct {
 	mock.Mock
 	session *discordgo.Session
+
+	// messages are the embeds posted in the text channel by message id
+	messagesMu sync.Mutex
+	messages   map[string][]*discordgo.MessageEmbed
+	edits      int
+
+	// voice holds the voice channel of each member, moves change it and
+	// moveErr makes them fail
+	voiceMu       sync.Mutex
+	voice         map[string]string
+	moves         []string
+	moveErr       error
+	permissionErr error
+
+	// memberRoles and nicknames are changed by the lane roles, removeErr
+	// makes the role removals fail
+	membersMu   sync.Mutex
+	memberRoles map[string]string
+	nicknames   map[string]string
+	removeErr   error
+}
+
+func (m *MockDiscordManager) Member(guildID string, userID string) (*discordgo.Member, error) {
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+	return &discordgo.Member{User: &discordgo.User{ID: userID, Username: userID}, Nick: m.nicknames[userID]}, nil
+}
+
+func (m *MockDiscordManager) EnsureRole(guildID string, name string) (*discordgo.Role, error) {
+	return &discordgo.Role{ID: strings.ToLower(name), Name: name}, nil
+}
+
+func (m *MockDiscordManager) AddMemberRole(guildID string, userID string, roleID string) error {
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+	if m.memberRoles == nil {
+		m.memberRoles = map[string]string{}
+	}
+	m.memberRoles[userID] = roleID
+	return nil
+}
+
+func (m *MockDiscordManager) RemoveMemberRole(guildID string, userID string, roleID string) error {
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+	if m.removeErr != nil {
+		return m.removeErr
+	}
+	if m.memberRoles[userID] == roleID {
+		delete(m.memberRoles, userID)
+	}
+	return nil
+}
+
+func (m *MockDiscordManager) SetNickname(guildID string, userID string, nickname string) error {
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+	if m.nicknames == nil {
+		m.nicknames = map[string]string{}
+	}
+	m.nicknames[userID] = nickname
+	return nil
+}
+
+// member returns the lane role and the nickname of a member.
+func (m *MockDiscordManager) member(userID string) (string, string) {
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+	return m.memberRoles[userID], m.nicknames[userID]
+}
+
+func (m *MockDiscordManager) CheckPermissions(channelID string, permissions int64) error {
+	return m.permissionErr
+}
+
+func (m *MockDiscordManager) EnsureVoiceChannel(guildID string, nextToChannelID string, name string) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: strings.ToLower(strings.ReplaceAll(name, " ", "-")), Name: name}, nil
+}
+
+func (m *MockDiscordManager) VoiceChannelID(guildID string, userID string) string {
+	m.voiceMu.Lock()
+	defer m.voiceMu.Unlock()
+	return m.voice[userID]
+}
+
+func (m *MockDiscordManager) MoveMember(guildID string, userID string, channelID string) error {
+	m.voiceMu.Lock()
+	defer m.voiceMu.Unlock()
+	if m.moveErr != nil {
+		return m.moveErr
+	}
+	m.voice[userID] = channelID
+	m.moves = append(m.moves, userID+">"+channelID)
+	return nil
+}
+
+// movesDone returns the moves once n of them were made.
+func (m *MockDiscordManager) movesDone(n int) []string {
+	m.voiceMu.Lock()
+	defer m.voiceMu.Unlock()
+	if len(m.moves) < n {
+		return nil
+	}
+	return append([]string{}, m.moves...)
 }
 
 func (m *MockDiscordManager) Session() *discordgo.Session {
@@ -34,6 +152,44 @@ func (m *MockDiscordManager) GetConfigChannel() (*discordgo.Channel, error) {
 	return args.Get(0).(*discordgo.Channel), args.Error(1)
 }
 
+func (m *MockDiscordManager) SendTextMessage(content string) error {
+	return nil
+}
+
+func (m *MockDiscordManager) SendEmbeds(embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) (string, error) {
+	m.messagesMu.Lock()
+	defer m.messagesMu.Unlock()
+	if m.messages == nil {
+		m.messages = map[string][]*discordgo.MessageEmbed{}
+	}
+	id := fmt.Sprintf("message-%d", len(m.messages)+1)
+	m.messages[id] = embeds
+	return id, nil
+}
+
+func (m *MockDiscordManager) EditEmbeds(messageID string, embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) error {
+	m.messagesMu.Lock()
+	defer m.messagesMu.Unlock()
+	if _, ok := m.messages[messageID]; !ok {
+		return fmt.Errorf("unknown message '%s'", messageID)
+	}
+	m.messages[messageID] = embeds
+	m.edits++
+	return nil
+}
+
+// message returns the header description of a posted message and the
+// number of messages and edits.
+func (m *MockDiscordManager) message(id string) (string, int, int) {
+	m.messagesMu.Lock()
+	defer m.messagesMu.Unlock()
+	description := ""
+	if embeds := m.messages[id]; len(embeds) > 0 {
+		description = embeds[0].Description
+	}
+	return description, len(m.messages), m.edits
+}
+
 type MockDependencies struct {
 	db *gorm.DB
 }
@@ -56,7 +212,7 @@ func setupTest(t *testing.T) (*gameManager, *MockDiscordManager, *MockDependenci
 	}
 
 	// Setup mock dependencies with an in-memory sqlite database
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s-%d?mode=memory&cache=shared", t.Name(), time.Now().UnixNano())), &gorm.Config{})
 	assert.NoError(t, err)
 
 	// Auto-migrate the schema
@@ -65,11 +221,15 @@ func setupTest(t *testing.T) (*gameManager, *MockDiscordManager, *MockDependenci
 		&sharedmodel.Player{},
 		&sharedmodel.GamePlayer{},
 		&sharedmodel.GamePlayerRoll{},
+		&sharedmodel.GamePause{},
 		&sharedmodel.Champion{},
 		&sharedmodel.PlayerChampion{},
 		&sharedmodel.WeeklyChampion{},
 		&sharedmodel.LaneRole{},
 		&sharedmodel.LeagueVersion{},
+		&sharedmodel.LobbyChannel{},
+		&sharedmodel.LaneAssignment{},
+		&sharedmodel.TeamMove{},
 	)
 	assert.NoError(t, err)
 
@@ -88,7 +248,7 @@ func setupTest(t *testing.T) (*gameManager, *MockDiscordManager, *MockDependenci
 	}
 
 	// Create the game manager
-	gm := NewGameManager(mockDeps, mockDM).(*gameManager)
+	gm := NewGameManager(mockDeps, mockDM, rostertest.NewFake()).(*gameManager)
 
 	// Set a logger
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, nil)))
@@ -107,6 +267,25 @@ func setupTest(t *testing.T) (*gameManager, *MockDiscordManager, *MockDependenci
 	return gm, mockDM, mockDeps
 }
 
+// fakeRoster returns the roster the test game manager was created with.
+func fakeRoster(gm *gameManager) *rostertest.Fake {
+	return gm.roster.(*rostertest.Fake)
+}
+
+// tick runs one timer tick on the game state loop.
+func tick(gm *gameManager) {
+	gm.do(context.Background(), func() error {
+		gm.tick()
+		return nil
+	})
+}
+
+// requestAs simulates the websocket upgrade request of a logged in player.
+func requestAs(playerID string) *http.Request {
+	r := &http.Request{URL: &url.URL{}}
+	return r.WithContext(auth.WithSession(context.Background(), &auth.Session{PlayerID: playerID}))
+}
+
 func TestE2EGameFlow(t *testing.T) {
 	gm, mockDM, mockDeps := setupTest(t)
 
@@ -123,14 +302,6 @@ func TestE2EGameFlow(t *testing.T) {
 						Name: "Test Channel",
 					},
 				},
-				VoiceStates: []*discordgo.VoiceState{
-					{UserID: "player1", ChannelID: "test-channel"},
-					{UserID: "player2", ChannelID: "test-channel"},
-				},
-				Members: []*discordgo.Member{
-					{User: &discordgo.User{ID: "player1"}, Nick: "Player 1"},
-					{User: &discordgo.User{ID: "player2"}, Nick: "Player 2"},
-				},
 			},
 		}
 
@@ -139,13 +310,17 @@ func TestE2EGameFlow(t *testing.T) {
 		internal.Config().Discord.ChannelID = "test-channel"
 
 		gm.onGuildCreate(mockDM.Session(), guildCreate)
+		fakeRoster(gm).Set(
+			roster.Member{ID: "player1", Name: "Player 1"},
+			roster.Member{ID: "player2", Name: "Player 2"},
+		)
 
 		// Assert that available players are updated
-		gm.gsMu.RLock()
-		assert.Len(t, gm.gs.AvailablePlayers, 2)
-		assert.Contains(t, gm.gs.AvailablePlayers, "player1")
-		assert.Contains(t, gm.gs.AvailablePlayers, "player2")
-		gm.gsMu.RUnlock()
+		gs := gm.State()
+		assert.Len(t, gs.AvailablePlayers, 2)
+		assert.Contains(t, gs.AvailablePlayers, "player1")
+		assert.Contains(t, gs.AvailablePlayers, "player2")
+		assert.Equal(t, "player1", gs.HostID)
 
 		// Assert that players are in the database
 		var players []sharedmodel.Player
@@ -155,47 +330,44 @@ func TestE2EGameFlow(t *testing.T) {
 
 	// 2. Getting the players updated for the current game
 	t.Run("Update players for the game", func(t *testing.T) {
-		updateContent := []map[string]interface{}{
-			{"id": "player1"},
-			{"id": "player2"},
-			{"id": "player3"}, // This player is not in available players
-			{"id": "player4"},
-			{"id": "player5"},
-		}
-		updateContentBytes, _ := json.Marshal(updateContent)
 		updateMessage := &modelwebsocket.Message{
-			Action:  modelwebsocket.UpdatePlayers,
-			Content: string(updateContentBytes),
+			ID:     "update-1",
+			Action: modelwebsocket.UpdatePlayers,
+			Payload: json.RawMessage(`{"players":[
+				{"id":"player1"},
+				{"id":"player2"},
+				{"id":"player3"},
+				{"id":"player4"},
+				{"id":"player5"}
+			]}`), // player3 is not in available players
 		}
 
-		gm.HandleWebsocketMessage(updateMessage, nil, &http.Request{})
+		gm.HandleWebsocketMessage(updateMessage, nil, requestAs("player1"))
 
 		time.Sleep(100 * time.Millisecond) // Allow time for the go routine to execute
 
-		gm.gsMu.RLock()
-		assert.Len(t, gm.gs.Players, 5)
-		assert.Equal(t, "player1", gm.gs.Players[0].Player.ID)
-		assert.Equal(t, "player2", gm.gs.Players[1].Player.ID)
-		assert.Equal(t, "", gm.gs.Players[2].Player.ID)
-		gm.gsMu.RUnlock()
+		gs := gm.State()
+		assert.Len(t, gs.Players, 5)
+		assert.Equal(t, "player1", gs.Players[0].Player.ID)
+		assert.Equal(t, "player2", gs.Players[1].Player.ID)
+		assert.Equal(t, "", gs.Players[2].Player.ID)
 	})
 
 	// 3. Starting a game with a first roll
 	t.Run("Start game with first roll", func(t *testing.T) {
 		rollMessage := &modelwebsocket.Message{Action: modelwebsocket.Roll}
-		gm.HandleWebsocketMessage(rollMessage, nil, &http.Request{})
+		gm.HandleWebsocketMessage(rollMessage, nil, requestAs("player1"))
 
 		time.Sleep(100 * time.Millisecond) // Allow time for the go routine to execute
 
-		gm.gsMu.RLock()
-		assert.True(t, gm.gs.GameInProgress)
-		assert.Equal(t, uint(1), gm.gs.RollCount)
-		assert.NotNil(t, gm.gs.Players[0].Role)
-		assert.NotNil(t, gm.gs.Players[1].Role)
-		assert.NotNil(t, gm.gs.Players[0].Champion)
-		assert.NotNil(t, gm.gs.Players[1].Champion)
-		gameID := gm.gs.GameId
-		gm.gsMu.RUnlock()
+		gs := gm.State()
+		assert.True(t, gs.GameInProgress)
+		assert.Equal(t, uint(1), gs.RollCount)
+		assert.NotNil(t, gs.Players[0].Role)
+		assert.NotNil(t, gs.Players[1].Role)
+		assert.NotNil(t, gs.Players[0].Champion)
+		assert.NotNil(t, gs.Players[1].Champion)
+		gameID := gs.GameId
 
 		// Assert that game data is in the database
 		var game sharedmodel.Game
@@ -214,19 +386,20 @@ func TestE2EGameFlow(t *testing.T) {
 	// 4. Getting a second roll
 	t.Run("Second roll", func(t *testing.T) {
 		// Reset CanRoll for testing purposes
-		gm.gsMu.Lock()
-		gm.gs.CanRoll = true
-		gameID := gm.gs.GameId
-		gm.gsMu.Unlock()
+		var gameID uint
+		gm.do(context.Background(), func() error {
+			gm.gs.CanRoll = true
+			gameID = gm.gs.GameId
+			return nil
+		})
 
 		rollMessage := &modelwebsocket.Message{Action: modelwebsocket.Roll}
-		gm.HandleWebsocketMessage(rollMessage, nil, &http.Request{})
+		gm.HandleWebsocketMessage(rollMessage, nil, requestAs("player1"))
 
 		time.Sleep(100 * time.Millisecond) // Allow time for the go routine to execute
 
-		gm.gsMu.RLock()
-		assert.Equal(t, uint(2), gm.gs.RollCount)
-		gm.gsMu.RUnlock()
+		gs := gm.State()
+		assert.Equal(t, uint(2), gs.RollCount)
 
 		// Assert that new rolls are in the database
 		var gamePlayerRolls []sharedmodel.GamePlayerRoll
@@ -236,20 +409,17 @@ func TestE2EGameFlow(t *testing.T) {
 
 	// 5. Finishing the game
 	t.Run("Finish the game", func(t *testing.T) {
-		gm.gsMu.RLock()
-		gameID := gm.gs.GameId
-		gm.gsMu.RUnlock()
+		gameID := gm.State().GameId
 
 		cancelMessage := &modelwebsocket.Message{Action: modelwebsocket.Cancel}
-		gm.HandleWebsocketMessage(cancelMessage, nil, &http.Request{})
+		gm.HandleWebsocketMessage(cancelMessage, nil, requestAs("player1"))
 
 		time.Sleep(100 * time.Millisecond) // Allow time for the go routine to execute
 
-		gm.gsMu.RLock()
-		assert.False(t, gm.gs.GameInProgress)
-		assert.Equal(t, uint(0), gm.gs.RollCount)
-		assert.True(t, gm.gs.CanRoll)
-		gm.gsMu.RUnlock()
+		gs := gm.State()
+		assert.False(t, gs.GameInProgress)
+		assert.Equal(t, uint(0), gs.RollCount)
+		assert.True(t, gs.CanRoll)
 
 		// Assert that game data is deleted from the database
 		var game sharedmodel.Game
@@ -265,3 +435,1722 @@ func TestE2EGameFlow(t *testing.T) {
 		assert.Len(t, gamePlayerRolls, 0)
 	})
 }
+
+// joinLobby simulates the given players being in the voice channel and
+// slotted by the host.
+func joinLobby(t *testing.T, gm *gameManager, mockDM *MockDiscordManager, ids ...string) {
+	internal.Config().Discord.GuildID = "test-guild"
+	internal.Config().Discord.ChannelID = "test-channel"
+	ms := make([]roster.Member, 0, len(ids))
+	slots := make([]model.PlayerSlot, 0, len(ids))
+	for _, id := range ids {
+		ms = append(ms, roster.Member{ID: id, Name: id})
+		slots = append(slots, model.PlayerSlot{ID: id})
+	}
+	gm.onGuildCreate(mockDM.Session(), &discordgo.GuildCreate{
+		Guild: &discordgo.Guild{
+			ID:       "test-guild",
+			Channels: []*discordgo.Channel{{ID: "test-channel", Name: "Test Channel"}},
+		},
+	})
+	fakeRoster(gm).Set(ms...)
+	assert.NoError(t, gm.UpdatePlayers(context.Background(), Actor{PlayerID: ids[0]}, slots))
+}
+
+func TestLobbyPermissions(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+
+	t.Run("Spectators can not change the game state", func(t *testing.T) {
+		_, err := gm.Roll(ctx, Actor{}, RollOptions{})
+		assert.ErrorIs(t, err, ErrForbidden)
+		assert.False(t, gm.State().GameInProgress)
+	})
+
+	t.Run("Players can not change the game state unless allowed", func(t *testing.T) {
+		_, err := gm.Roll(ctx, Actor{PlayerID: "player2"}, RollOptions{})
+		assert.ErrorIs(t, err, ErrForbidden)
+		assert.False(t, gm.State().GameInProgress)
+
+		gm.do(context.Background(), func() error {
+			assert.Equal(t, model.LobbyRolePlayer, gm.lobbyRole("player2"))
+			return nil
+		})
+	})
+
+	t.Run("Player ids sent by the client are ignored", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/ws?playerId=player1", nil)
+		r.Header.Set("X-Player-Id", "player1")
+		assert.Equal(t, "", actorFromRequest(r).PlayerID)
+		assert.Equal(t, "player1", actorFromRequest(requestAs("player1")).PlayerID)
+	})
+
+	t.Run("Host moves when the host leaves the voice channel", func(t *testing.T) {
+		fakeRoster(gm).Leave("player1")
+
+		assert.Equal(t, "player2", gm.State().HostID)
+		gm.do(context.Background(), func() error {
+			assert.Equal(t, model.LobbyRoleHost, gm.lobbyRole("player2"))
+			return nil
+		})
+	})
+}
+
+func TestGameSettings(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	t.Run("Banned champions must be known once", func(t *testing.T) {
+		settings := model.NewDefaultGameSettings(0)
+		settings.BannedChampionIDs = []string{"1", "404"}
+		assert.ErrorIs(t, gm.UpdateSettings(ctx, host, settings), ErrInvalidContent)
+		settings.BannedChampionIDs = []string{"1", "1"}
+		assert.ErrorIs(t, gm.UpdateSettings(ctx, host, settings), ErrInvalidContent)
+		assert.Empty(t, gm.State().Settings.BannedChampionIDs)
+	})
+
+	assert.NoError(t, gm.UpdateSettings(ctx, host, model.GameSettings{
+		Cooldown:              1000,
+		MaxRerolls:            1,
+		RollStrategy:          model.RollStrategyKeepRoles,
+		IncludeWeeklyRotation: false,
+		BannedChampionIDs:     []string{"1", "2"},
+	}))
+
+	res, err := gm.Roll(ctx, host, RollOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), res.RollCount)
+
+	gs := gm.State()
+	assert.True(t, gs.GameInProgress)
+	assert.Equal(t, uint(1000), gs.NextRollTimer)
+	roles := []model.Role{*gs.Players[0].Role, *gs.Players[1].Role}
+	for _, p := range gs.Players[:2] {
+		assert.NotContains(t, []string{"1", "2"}, p.Champion.ID)
+	}
+
+	var game sharedmodel.Game
+	assert.NoError(t, mockDeps.db.First(&game, res.GameID).Error)
+	assert.Equal(t, uint(1), game.Settings.MaxRerolls)
+	assert.Equal(t, string(model.RollStrategyKeepRoles), game.Settings.RollStrategy)
+	assert.Equal(t, []string{"1", "2"}, game.Settings.BannedChampionIDs)
+
+	t.Run("Settings are fixed while the game is in progress", func(t *testing.T) {
+		err := gm.UpdateSettings(ctx, host, model.NewDefaultGameSettings(0))
+		assert.ErrorIs(t, err, ErrGameInProgress)
+		assert.Equal(t, uint(1), gm.State().Settings.MaxRerolls)
+	})
+
+	t.Run("Cooldown allows the reroll and keeps the roles", func(t *testing.T) {
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.ErrorIs(t, err, ErrCooldownActive)
+
+		tick(gm)
+		assert.True(t, gm.State().CanRoll)
+
+		res, err := gm.Roll(ctx, host, RollOptions{RerollOnly: true})
+		assert.NoError(t, err)
+		assert.Equal(t, uint(2), res.RollCount)
+		assert.Equal(t, roles, []model.Role{*res.Players[0].Role, *res.Players[1].Role})
+	})
+
+	t.Run("No rolls are allowed past the max rerolls", func(t *testing.T) {
+		tick(gm)
+		assert.False(t, gm.State().CanRoll)
+
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.ErrorIs(t, err, ErrRerollsExhausted)
+	})
+}
+
+func TestZeroCooldown(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	settings := model.NewDefaultGameSettings(0)
+	settings.MaxRerolls = 1
+	assert.NoError(t, gm.UpdateSettings(ctx, host, settings))
+
+	_, err := gm.Roll(ctx, host, RollOptions{})
+	assert.NoError(t, err)
+	gs := gm.State()
+	assert.Equal(t, uint(0), gs.NextRollTimer)
+	assert.True(t, gs.CanRoll)
+
+	res, err := gm.Roll(ctx, host, RollOptions{RerollOnly: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), res.RollCount)
+	assert.False(t, gm.State().CanRoll)
+	_, err = gm.Roll(ctx, host, RollOptions{RerollOnly: true})
+	assert.ErrorIs(t, err, ErrRerollsExhausted)
+}
+
+func TestReadyCheck(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2", "player3")
+	internal.Config().GameManager.ReadyCheckTime = 2000
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	assert.NoError(t, gm.StartReadyCheck(ctx, host))
+	gs := gm.State()
+	assert.True(t, gs.ReadyCheck.Active)
+	assert.Len(t, gs.ReadyCheck.Ready, 3)
+
+	t.Run("Rolling is blocked during the ready check", func(t *testing.T) {
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.ErrorIs(t, err, ErrReadyCheckInProgress)
+		assert.False(t, gm.State().GameInProgress)
+	})
+
+	t.Run("Players who did not answer are removed once the time is over", func(t *testing.T) {
+		assert.NoError(t, gm.Ready(ctx, Actor{PlayerID: "player1"}))
+		assert.NoError(t, gm.Ready(ctx, Actor{PlayerID: "player3"}))
+		assert.ErrorIs(t, gm.Ready(ctx, Actor{PlayerID: "spectator"}), ErrNotInReadyCheck)
+
+		gs := gm.State()
+		assert.True(t, gs.ReadyCheck.Ready["player1"])
+		assert.False(t, gs.ReadyCheck.Ready["player2"])
+
+		tick(gm)
+		tick(gm)
+
+		gs = gm.State()
+		assert.False(t, gs.ReadyCheck.Active)
+		assert.True(t, gs.GameInProgress)
+		assert.Equal(t, "player1", gs.Players[0].Player.ID)
+		assert.Equal(t, "", gs.Players[1].Player.ID)
+		assert.Equal(t, "player3", gs.Players[2].Player.ID)
+	})
+}
+
+func TestPauseTimer(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	res, err := gm.Roll(ctx, host, RollOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, gm.PauseTimer(ctx, host))
+
+	gm.do(context.Background(), func() error {
+		assert.True(t, gm.gs.TimerPaused)
+		assert.False(t, gm.gs.CanRoll)
+		gm.gs.NextRollTimer = 1000
+		return nil
+	})
+
+	t.Run("Paused timer does not count down", func(t *testing.T) {
+		tick(gm)
+		gs := gm.State()
+		assert.Equal(t, uint(1000), gs.NextRollTimer)
+		assert.False(t, gs.CanRoll)
+
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.ErrorIs(t, err, ErrTimerPaused)
+	})
+
+	t.Run("Resumed timer counts down and logs the pause", func(t *testing.T) {
+		assert.NoError(t, gm.ResumeTimer(ctx, host))
+
+		tick(gm)
+		gs := gm.State()
+		assert.False(t, gs.TimerPaused)
+		assert.Equal(t, uint(0), gs.NextRollTimer)
+		assert.True(t, gs.CanRoll)
+
+		var pauses []sharedmodel.GamePause
+		mockDeps.db.Find(&pauses, "game_id = ?", res.GameID)
+		assert.Len(t, pauses, 1)
+		assert.NotNil(t, pauses[0].ResumedAt)
+	})
+}
+
+// TestConcurrentCommands is meant to be run with the race detector, commands
+// and discord events are sent at the same time and must be applied one after
+// the other by the game state loop.
+func TestPoolAndStats(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1")
+	ctx := context.Background()
+	player := Actor{PlayerID: "player1"}
+
+	t.Run("Champions are found by name whatever the case", func(t *testing.T) {
+		c, err := gm.AddToPool(ctx, player, "ashe")
+		assert.NoError(t, err)
+		assert.Equal(t, "Ashe", c.Name)
+		_, err = gm.AddToPool(ctx, player, "ASHE")
+		assert.NoError(t, err)
+
+		_, err = gm.AddToPool(ctx, player, "Teemo")
+		assert.ErrorIs(t, err, ErrChampionNotFound)
+		_, err = gm.AddToPool(ctx, Actor{}, "Ashe")
+		assert.ErrorIs(t, err, ErrForbidden)
+	})
+
+	t.Run("Rolls only use the pool", func(t *testing.T) {
+		res, err := gm.Roll(ctx, player, RollOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "Ashe", res.Players[0].Champion.Name)
+
+		s, err := gm.Stats(ctx, "player1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), s.Games)
+		assert.Equal(t, int64(1), s.Rolls)
+		assert.Equal(t, int64(1), s.PoolSize)
+		if assert.Len(t, s.TopChampions, 1) {
+			assert.Equal(t, "Ashe", s.TopChampions[0].Champion.Name)
+			assert.Equal(t, int64(1), s.TopChampions[0].Count)
+		}
+	})
+
+	t.Run("Removed champions leave the pool", func(t *testing.T) {
+		_, err := gm.RemoveFromPool(ctx, player, "Ashe")
+		assert.NoError(t, err)
+		s, err := gm.Stats(ctx, "player1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), s.PoolSize)
+	})
+}
+
+func TestRollMessage(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	settings := gm.State().Settings
+	settings.Cooldown = 1000
+	assert.NoError(t, gm.UpdateSettings(ctx, host, settings))
+
+	res, err := gm.Roll(ctx, host, RollOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		d, n, _ := mockDM.message("message-1")
+		return d == "Roll #1" && n == 1
+	}, time.Second, 10*time.Millisecond)
+
+	t.Run("The message id is saved on the game", func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			var game sharedmodel.Game
+			mockDeps.db.First(&game, res.GameID)
+			return game.DiscordMessageID != nil && *game.DiscordMessageID == "message-1"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Rerolls edit the message", func(t *testing.T) {
+		tick(gm)
+		_, err := gm.Roll(ctx, host, RollOptions{RerollOnly: true})
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			d, n, edits := mockDM.message("message-1")
+			return d == "Roll #2" && n == 1 && edits == 1
+		}, time.Second, 10*time.Millisecond)
+
+		gm.do(ctx, func() error {
+			embeds := rollEmbeds(*gm.gs, rollMessageLive)
+			assert.Len(t, embeds, 3)
+			c := gm.gs.Players[0].Champion
+			assert.Equal(t, "https://ddragon.leagueoflegends.com/cdn/14.1.1/img/champion/"+c.Img, embeds[1].Thumbnail.URL)
+			return nil
+		})
+	})
+
+	t.Run("The message is closed when the game is finished", func(t *testing.T) {
+		assert.NoError(t, gm.Reset(ctx, host))
+		assert.Eventually(t, func() bool {
+			d, _, _ := mockDM.message("message-1")
+			return d == "Finished after 2 rolls"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("A new game posts a new message that is closed on cancel", func(t *testing.T) {
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, gm.Cancel(ctx, host))
+		assert.Eventually(t, func() bool {
+			d, n, _ := mockDM.message("message-2")
+			return d == "Cancelled" && n == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("The message of a deleted game is closed", func(t *testing.T) {
+		// the message was posted before a restart so its id is only in the
+		// database
+		id, err := mockDM.SendEmbeds(nil, nil)
+		assert.NoError(t, err)
+		game := sharedmodel.Game{DiscordMessageID: &id}
+		assert.NoError(t, mockDeps.db.Create(&game).Error)
+		assert.NoError(t, mockDeps.db.Delete(&game).Error)
+
+		gs := gm.State()
+		gs.GameId = game.ID
+		gm.rollMessages.push(rollMessage{
+			gameID: game.ID,
+			status: rollMessageCancelled,
+			embeds: rollEmbeds(gs, rollMessageCancelled),
+		})
+		assert.Eventually(t, func() bool {
+			d, _, _ := mockDM.message(id)
+			return d == "Cancelled"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("The message is closed when discord is behind", func(t *testing.T) {
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			d, n, _ := mockDM.message("message-4")
+			return d == "Roll #1" && n == 4
+		}, time.Second, 10*time.Millisecond)
+
+		// discord hangs while the updates pile up
+		mockDM.messagesMu.Lock()
+		for i := 0; i < 50; i++ {
+			gm.do(ctx, func() error {
+				gm.queueRollMessage(rollMessageLive)
+				return nil
+			})
+		}
+		assert.NoError(t, gm.Cancel(ctx, host))
+		gm.rollMessages.mu.Lock()
+		waiting := len(gm.rollMessages.jobs)
+		gm.rollMessages.mu.Unlock()
+		assert.LessOrEqual(t, waiting, 2)
+		mockDM.messagesMu.Unlock()
+
+		assert.Eventually(t, func() bool {
+			d, _, _ := mockDM.message("message-4")
+			return d == "Cancelled"
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestLockPick(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	_, err := gm.LockPick(ctx, host)
+	assert.ErrorIs(t, err, ErrNoGameInProgress)
+
+	settings := gm.State().Settings
+	settings.Cooldown = 1000
+	assert.NoError(t, gm.UpdateSettings(ctx, host, settings))
+	first, err := gm.Roll(ctx, host, RollOptions{})
+	assert.NoError(t, err)
+
+	_, err = gm.LockPick(ctx, Actor{PlayerID: "spectator"})
+	assert.ErrorIs(t, err, ErrNotInGame)
+	locked, err := gm.LockPick(ctx, Actor{PlayerID: "player2"})
+	assert.NoError(t, err)
+	assert.True(t, locked)
+
+	t.Run("Locked picks are kept on reroll", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			tick(gm)
+			res, err := gm.Roll(ctx, host, RollOptions{RerollOnly: true})
+			assert.NoError(t, err)
+			assert.Equal(t, *first.Players[1].Role, *res.Players[1].Role)
+			assert.Equal(t, first.Players[1].Champion.ID, res.Players[1].Champion.ID)
+			assert.NotEqual(t, *res.Players[1].Role, *res.Players[0].Role)
+			assert.NotEqual(t, res.Players[1].Champion.ID, res.Players[0].Champion.ID)
+		}
+	})
+
+	t.Run("Locks are cleared on reset", func(t *testing.T) {
+		locked, err := gm.LockPick(ctx, Actor{PlayerID: "player2"})
+		assert.NoError(t, err)
+		assert.False(t, locked)
+		_, err = gm.LockPick(ctx, Actor{PlayerID: "player2"})
+		assert.NoError(t, err)
+
+		assert.NoError(t, gm.Reset(ctx, host))
+		assert.False(t, gm.State().Players[1].Locked)
+		assert.Nil(t, rollComponents(rollMessageFinished))
+	})
+}
+
+func TestRefreshDiscord(t *testing.T) {
+	gm, _, _ := setupTest(t)
+	ctx := context.Background()
+
+	fakeRoster(gm).Err = errors.New("guild not found")
+	assert.ErrorIs(t, gm.RefreshDiscord(ctx, Actor{}), ErrDiscordUnavailable)
+	fakeRoster(gm).Err = nil
+
+	fakeRoster(gm).Put(
+		roster.Member{ID: "player1", Name: "nick-player1"},
+		roster.Member{ID: "player2", Name: "nick-player2"},
+	)
+	gm.do(ctx, func() error {
+		// the gateway missed the leave of ghost
+		id, name := "ghost", "ghost"
+		gm.gs.AvailablePlayers[id] = model.AvailablePlayer{ID: &id, Name: &name}
+		gm.gs.Players[0].Player = model.DiscordPlayer{ID: id, Name: &name}
+		return nil
+	})
+
+	assert.NoError(t, gm.RefreshDiscord(ctx, Actor{}))
+	gs := gm.State()
+	assert.Len(t, gs.AvailablePlayers, 2)
+	assert.Equal(t, "nick-player1", *gs.AvailablePlayers["player1"].Name)
+	assert.Contains(t, gs.AvailablePlayers, "player2")
+	assert.Equal(t, "", gs.Players[0].Player.ID)
+}
+
+func TestSwitchVoiceChannel(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	st := discordgo.NewState()
+	assert.NoError(t, st.GuildAdd(&discordgo.Guild{
+		ID: "test-guild",
+		Channels: []*discordgo.Channel{
+			{ID: "lounge", Name: "Lounge", Type: discordgo.ChannelTypeGuildVoice, Position: 2},
+			{ID: "test-channel", Name: "Test Channel", Type: discordgo.ChannelTypeGuildVoice, Position: 1},
+			{ID: "text", Name: "Text", Type: discordgo.ChannelTypeGuildText},
+		},
+		VoiceStates: []*discordgo.VoiceState{
+			{GuildID: "test-guild", ChannelID: "lounge", UserID: "player1"},
+			{GuildID: "test-guild", ChannelID: "test-channel", UserID: "player2"},
+			{GuildID: "test-guild", ChannelID: "lounge", UserID: "player3"},
+		},
+		Members: []*discordgo.Member{
+			{GuildID: "test-guild", Nick: "player1", User: &discordgo.User{ID: "player1"}},
+			{GuildID: "test-guild", Nick: "player2", User: &discordgo.User{ID: "player2"}},
+			{GuildID: "test-guild", Nick: "player3", User: &discordgo.User{ID: "player3"}},
+		},
+	}))
+	mockDM.session.State = st
+
+	t.Run("Voice channels are listed on the websocket", func(t *testing.T) {
+		conn := dialLobby(t, gm, "player2")
+		m, err := modelwebsocket.NewMessage("list", modelwebsocket.ListVoiceChannels, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.WriteJSON(m))
+		reply := readReply(t, conn, "list")
+		assert.Equal(t, modelwebsocket.VoiceChannels, reply.Action)
+		var p modelwebsocket.VoiceChannelsPayload
+		assert.NoError(t, reply.DecodePayload(&p))
+		assert.Equal(t, []model.VoiceChannel{
+			{ID: "test-channel", Name: "Test Channel", Members: 1},
+			{ID: "lounge", Name: "Lounge", Members: 2},
+		}, p.Channels)
+		assert.Equal(t, modelwebsocket.Ack, readReply(t, conn, "list").Action)
+	})
+
+	t.Run("Only the host switches to a voice channel", func(t *testing.T) {
+		assert.ErrorIs(t, gm.SwitchVoiceChannel(ctx, Actor{PlayerID: "player2"}, "lounge"), ErrForbidden)
+		assert.ErrorIs(t, gm.SwitchVoiceChannel(ctx, host, "text"), ErrChannelNotFound)
+		assert.Equal(t, "test-channel", gm.State().DiscordGuildChannelID)
+	})
+
+	t.Run("Switching reloads the members and saves the channel", func(t *testing.T) {
+		// the members of the lounge
+		fakeRoster(gm).Put(roster.Member{ID: "player1", Name: "player1"}, roster.Member{ID: "player3", Name: "player3"})
+		assert.NoError(t, gm.SwitchVoiceChannel(ctx, host, "lounge"))
+		assert.Equal(t, []string{"lounge"}, fakeRoster(gm).Lobby())
+		gs := gm.State()
+		assert.Equal(t, "lounge", gs.DiscordGuildChannelID)
+		assert.Equal(t, "Lounge", gs.DiscordGuildChannelName)
+		assert.Len(t, gs.AvailablePlayers, 2)
+		assert.Contains(t, gs.AvailablePlayers, "player3")
+		assert.Equal(t, "player1", gs.Players[0].Player.ID)
+		assert.Equal(t, "", gs.Players[1].Player.ID)
+
+		var lc sharedmodel.LobbyChannel
+		assert.NoError(t, mockDeps.db.First(&lc, "guild_id = ?", "test-guild").Error)
+		assert.Equal(t, "lounge", lc.ChannelID)
+		assert.Equal(t, "lounge", gm.loadChannelID(ctx))
+	})
+}
+
+func TestTeams(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	ctx := context.Background()
+	for i := 6; i <= 10; i++ {
+		mockDeps.db.Create(&sharedmodel.Champion{ID: fmt.Sprint(i), Name: fmt.Sprintf("Champion%d", i), Img: fmt.Sprintf("Champion%d.png", i)})
+	}
+	ids := make([]string, 0, 10)
+	slots := make([]model.PlayerSlot, 0, 10)
+	mockDM.voice = map[string]string{}
+	for i := 1; i <= 10; i++ {
+		id := fmt.Sprintf("player%d", i)
+		ids = append(ids, id)
+		slots = append(slots, model.PlayerSlot{ID: id})
+		mockDM.voice[id] = "test-channel"
+	}
+	mockDM.voice["player10"] = "elsewhere"
+	joinLobby(t, gm, mockDM, ids...)
+	host := Actor{PlayerID: "player1"}
+	settings := gm.State().Settings
+	settings.Teams = 2
+	settings.MoveToTeamChannels = true
+
+	t.Run("Moving the teams needs the discord permissions", func(t *testing.T) {
+		mockDM.permissionErr = fmt.Errorf("%w : Move Members in channel 'test-channel'", discord.ErrMissingPermission)
+		err := gm.UpdateSettings(ctx, host, settings)
+		assert.ErrorIs(t, err, ErrMissingPermission)
+		assert.Contains(t, err.Error(), "Move Members")
+		mockDM.permissionErr = nil
+
+		settings.Teams = 3
+		assert.ErrorIs(t, gm.UpdateSettings(ctx, host, settings), ErrInvalidContent)
+		settings.Teams = 2
+	})
+
+	t.Run("Two teams have ten slots with every role in each team", func(t *testing.T) {
+		assert.NoError(t, gm.UpdateSettings(ctx, host, settings))
+		assert.Len(t, gm.State().Players, 10)
+		assert.NoError(t, gm.UpdatePlayers(ctx, host, slots))
+
+		res, err := gm.Roll(ctx, host, RollOptions{})
+		assert.NoError(t, err)
+		for team := 0; team < 2; team++ {
+			roles := map[model.Role]bool{}
+			for _, p := range res.Players[team*model.TeamSize : (team+1)*model.TeamSize] {
+				roles[*p.Role] = true
+			}
+			assert.Len(t, roles, model.TeamSize)
+		}
+		gm.do(ctx, func() error {
+			embeds := rollEmbeds(*gm.gs, rollMessageLive)
+			assert.Len(t, embeds, maxEmbeds)
+			assert.Equal(t, fmt.Sprintf("Loi des norms #%d", res.GameID), embeds[0].Title)
+			return nil
+		})
+	})
+
+	t.Run("Players in the lobby channel are moved in their team channel", func(t *testing.T) {
+		var moves []string
+		assert.Eventually(t, func() bool {
+			moves = mockDM.movesDone(9)
+			return moves != nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Contains(t, moves, "player1>team-1")
+		assert.Contains(t, moves, "player6>team-2")
+		assert.NotContains(t, moves, "player10>team-2")
+
+		// the team channels are part of the lobby
+		assert.ElementsMatch(t, []string{"test-channel", "team-1", "team-2"}, fakeRoster(gm).Lobby())
+	})
+
+	t.Run("Players are moved back once the game is finished", func(t *testing.T) {
+		assert.NoError(t, gm.Reset(ctx, host))
+		assert.Eventually(t, func() bool {
+			return mockDM.movesDone(18) != nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "test-channel", mockDM.VoiceChannelID("test-guild", "player6"))
+		assert.Eventually(t, func() bool {
+			var n int
+			gm.do(ctx, func() error {
+				n = len(gm.teamChannels)
+				return nil
+			})
+			return n == 0
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"test-channel"}, fakeRoster(gm).Lobby())
+
+		var n int64
+		mockDeps.db.Model(&sharedmodel.TeamMove{}).Count(&n)
+		assert.Zero(t, n)
+	})
+
+	t.Run("Failed moves back are retried on startup", func(t *testing.T) {
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return mockDM.movesDone(27) != nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Eventually(t, func() bool {
+			var n int64
+			mockDeps.db.Model(&sharedmodel.TeamMove{}).Count(&n)
+			return n == 9
+		}, time.Second, 10*time.Millisecond)
+
+		mockDM.voiceMu.Lock()
+		mockDM.moveErr = fmt.Errorf("discord is down")
+		mockDM.voiceMu.Unlock()
+		assert.NoError(t, gm.Reset(ctx, host))
+		assert.Eventually(t, func() bool {
+			var n int
+			gm.do(ctx, func() error {
+				n = len(gm.teamChannels)
+				return nil
+			})
+			return n == 0
+		}, time.Second, 10*time.Millisecond)
+		var n int64
+		mockDeps.db.Model(&sharedmodel.TeamMove{}).Count(&n)
+		assert.Equal(t, int64(9), n)
+
+		mockDM.voiceMu.Lock()
+		mockDM.moveErr = nil
+		mockDM.voiceMu.Unlock()
+		gm.onDiscordReady(mockDM.Session(), &discordgo.Ready{Guilds: []*discordgo.Guild{{ID: "test-guild", Name: "Test Guild"}}})
+		assert.Eventually(t, func() bool {
+			return mockDM.movesDone(36) != nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "test-channel", mockDM.VoiceChannelID("test-guild", "player6"))
+		assert.Eventually(t, func() bool {
+			var n int64
+			mockDeps.db.Model(&sharedmodel.TeamMove{}).Count(&n)
+			return n == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestJobQueue(t *testing.T) {
+	q := newJobQueue("jobs", func(job string, waiting string) bool {
+		return strings.HasPrefix(job, "clear") || !strings.HasPrefix(waiting, "clear")
+	})
+	pending := func() []string {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return append([]string{}, q.jobs...)
+	}
+
+	t.Run("A live job replaces the live job waiting before it", func(t *testing.T) {
+		q.push("assign 1")
+		q.push("assign 2")
+		assert.Equal(t, []string{"assign 2"}, pending())
+	})
+
+	t.Run("A cleanup job is never dropped", func(t *testing.T) {
+		q.push("clear 1")
+		q.push("assign 3")
+		q.push("assign 4")
+		assert.Equal(t, []string{"clear 1", "assign 4"}, pending())
+		q.push("clear 2")
+		assert.Equal(t, []string{"clear 2"}, pending())
+	})
+
+	t.Run("The jobs are applied in order", func(t *testing.T) {
+		q.push("assign 5")
+		done := make(chan string)
+		go q.run(func(job string) { done <- job })
+		assert.Equal(t, "clear 2", <-done)
+		assert.Equal(t, "assign 5", <-done)
+		q.push("clear 3")
+		assert.Equal(t, "clear 3", <-done)
+	})
+}
+
+func TestLaneRoles(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+	mockDM.nicknames = map[string]string{"player1": "Bob"}
+	settings := gm.State().Settings
+	settings.LaneRoles = true
+	settings.ChampionNicknames = true
+	settings.Cooldown = 1000
+
+	t.Run("Lane roles need the discord permissions", func(t *testing.T) {
+		mockDM.permissionErr = fmt.Errorf("%w : Manage Roles in channel 'test-channel'", discord.ErrMissingPermission)
+		assert.ErrorIs(t, gm.UpdateSettings(ctx, host, settings), ErrMissingPermission)
+		mockDM.permissionErr = nil
+		assert.NoError(t, gm.UpdateSettings(ctx, host, settings))
+	})
+
+	assigned := func(res *RollResult, id string) func() bool {
+		return func() bool {
+			for _, p := range res.Players {
+				if p.Player.ID != id {
+					continue
+				}
+				role, nickname := mockDM.member(id)
+				return role == strings.ToLower(laneRolePrefix+string(*p.Role)) && strings.HasSuffix(nickname, fmt.Sprintf(" (%s)", p.Champion.Name))
+			}
+			return false
+		}
+	}
+
+	t.Run("Rolled players get their lane role and champion nickname", func(t *testing.T) {
+		res, err := gm.Roll(ctx, host, RollOptions{})
+		assert.NoError(t, err)
+		assert.Eventually(t, assigned(res, "player1"), time.Second, 10*time.Millisecond)
+		assert.Eventually(t, assigned(res, "player2"), time.Second, 10*time.Millisecond)
+		_, nickname := mockDM.member("player1")
+		assert.True(t, strings.HasPrefix(nickname, "Bob ("))
+
+		// a reroll keeps the original nickname
+		gm.do(ctx, func() error {
+			gm.tick()
+			return nil
+		})
+		res, err = gm.Roll(ctx, host, RollOptions{})
+		assert.NoError(t, err)
+		assert.Eventually(t, assigned(res, "player1"), time.Second, 10*time.Millisecond)
+		var a sharedmodel.LaneAssignment
+		assert.NoError(t, mockDeps.db.First(&a, "user_id = ?", "player1").Error)
+		assert.Equal(t, "Bob", a.Nickname)
+	})
+
+	t.Run("Champion nicknames don't rename the players", func(t *testing.T) {
+		_, nickname := mockDM.member("player2")
+		fakeRoster(gm).Update(roster.Member{ID: "player2", Name: nickname})
+		gs := gm.State()
+		assert.Equal(t, "player2", *gs.AvailablePlayers["player2"].Name)
+		assert.Equal(t, "player2", *gs.Players[1].Player.Name)
+		var p sharedmodel.Player
+		assert.NoError(t, mockDeps.db.First(&p, "id = ?", "player2").Error)
+		assert.Equal(t, "player2", *p.Name)
+
+		// a nickname changed by the member is still a rename
+		fakeRoster(gm).Update(roster.Member{ID: "player2", Name: "Alice"})
+		assert.Equal(t, "Alice", *gm.State().Players[1].Player.Name)
+		fakeRoster(gm).Update(roster.Member{ID: "player2", Name: "player2"})
+	})
+
+	t.Run("Leftovers are kept when the cleanup fails", func(t *testing.T) {
+		mockDM.membersMu.Lock()
+		mockDM.removeErr = fmt.Errorf("discord is down")
+		mockDM.membersMu.Unlock()
+		assert.NoError(t, gm.Reset(ctx, host))
+		assert.Eventually(t, func() bool {
+			_, nickname := mockDM.member("player1")
+			return nickname == "Bob"
+		}, time.Second, 10*time.Millisecond)
+		var as []sharedmodel.LaneAssignment
+		assert.Eventually(t, func() bool {
+			mockDeps.db.Find(&as)
+			return len(as) == 2 && !as[0].NicknameChanged && !as[1].NicknameChanged
+		}, time.Second, 10*time.Millisecond)
+		assert.NotEmpty(t, as[0].RoleID)
+	})
+
+	t.Run("Leftovers are removed on startup", func(t *testing.T) {
+		mockDM.membersMu.Lock()
+		mockDM.removeErr = nil
+		mockDM.membersMu.Unlock()
+		gm.onDiscordReady(mockDM.Session(), &discordgo.Ready{Guilds: []*discordgo.Guild{{ID: "test-guild", Name: "Test Guild"}}})
+		assert.Eventually(t, func() bool {
+			var n int64
+			mockDeps.db.Model(&sharedmodel.LaneAssignment{}).Count(&n)
+			return n == 0
+		}, time.Second, 10*time.Millisecond)
+		role, nickname := mockDM.member("player2")
+		assert.Empty(t, role)
+		assert.Empty(t, nickname)
+	})
+}
+
+func TestMemberSync(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	r := fakeRoster(gm)
+	avatar := "a1"
+
+	t.Run("Member updates rename the player everywhere", func(t *testing.T) {
+		r.Update(roster.Member{ID: "player1", Name: "Alice", Avatar: &avatar})
+		gs := gm.State()
+		assert.Equal(t, "Alice", *gs.AvailablePlayers["player1"].Name)
+		assert.Equal(t, "a1", *gs.AvailablePlayers["player1"].Avatar)
+		assert.Equal(t, "Alice", *gs.Players[0].Player.Name)
+		assert.Equal(t, "a1", *gs.Players[0].Player.Avatar)
+
+		var p sharedmodel.Player
+		assert.NoError(t, mockDeps.db.First(&p, "id = ?", "player1").Error)
+		assert.Equal(t, "Alice", *p.Name)
+		assert.Equal(t, "a1", *p.Avatar)
+	})
+
+	t.Run("Joining members are saved and slotted", func(t *testing.T) {
+		r.Join(roster.Member{ID: "player3", Name: "Carol"})
+		gs := gm.State()
+		assert.Equal(t, "Carol", *gs.AvailablePlayers["player3"].Name)
+		assert.Nil(t, gs.AvailablePlayers["player3"].Avatar)
+		assert.Equal(t, "player3", gs.Players[2].Player.ID)
+		var p sharedmodel.Player
+		assert.NoError(t, mockDeps.db.First(&p, "id = ?", "player3").Error)
+		assert.Equal(t, "Carol", *p.Name)
+
+		// joining again keeps a single slot
+		r.Join(roster.Member{ID: "player3", Name: "Carol"})
+		slots := 0
+		for _, p := range gm.State().Players {
+			if p.Player.ID == "player3" {
+				slots++
+			}
+		}
+		assert.Equal(t, 1, slots)
+	})
+
+	t.Run("Members outside the lobby are only saved", func(t *testing.T) {
+		r.Update(roster.Member{ID: "player4", Name: "user4"})
+		var p sharedmodel.Player
+		assert.NoError(t, mockDeps.db.First(&p, "id = ?", "player4").Error)
+		assert.Equal(t, "user4", *p.Name)
+		assert.NotContains(t, gm.State().AvailablePlayers, "player4")
+	})
+
+	t.Run("Leaving members leave the lobby", func(t *testing.T) {
+		r.Leave("player2")
+		gs := gm.State()
+		assert.NotContains(t, gs.AvailablePlayers, "player2")
+		for _, p := range gs.Players {
+			assert.NotEqual(t, "player2", p.Player.ID)
+		}
+	})
+}
+
+func TestManualRoster(t *testing.T) {
+	_, _, mockDeps := setupTest(t)
+	// no discord bot, the players are added by hand
+	gm := NewGameManager(mockDeps, nil, roster.NewManual()).(*gameManager)
+	ctx := context.Background()
+	host := Actor{PlayerID: "web1"}
+
+	t.Run("Spectators can not add players", func(t *testing.T) {
+		assert.ErrorIs(t, gm.AddRosterMember(ctx, Actor{}, "", "Alice"), ErrForbidden)
+		assert.ErrorIs(t, gm.AddRosterMember(ctx, host, "", " "), ErrInvalidContent)
+	})
+
+	t.Run("Players are added by hand", func(t *testing.T) {
+		assert.NoError(t, gm.AddRosterMember(ctx, host, "web1", "Alice"))
+		assert.NoError(t, gm.AddRosterMember(ctx, host, "", "Bob"))
+		assert.ErrorIs(t, gm.AddRosterMember(ctx, host, "", "bob"), ErrInvalidContent)
+		gs := gm.State()
+		assert.Len(t, gs.AvailablePlayers, 2)
+		assert.Equal(t, "Bob", *gs.AvailablePlayers["manual:bob"].Name)
+		assert.Equal(t, "web1", gs.HostID)
+		assert.Equal(t, "manual:bob", gs.Players[1].Player.ID)
+
+		// the host is set, only the ones allowed to control can edit
+		assert.ErrorIs(t, gm.AddRosterMember(ctx, Actor{PlayerID: "web2"}, "", "Carol"), ErrForbidden)
+	})
+
+	t.Run("The admin hosts the lobby without being a player", func(t *testing.T) {
+		admin := Actor{PlayerID: auth.AdminPlayerID}
+		assert.NoError(t, gm.AddRosterMember(ctx, admin, "", "Carol"))
+		assert.NoError(t, gm.RemoveRosterMember(ctx, admin, "manual:carol"))
+		assert.Equal(t, "web1", gm.State().HostID)
+	})
+
+	t.Run("A game is played without discord", func(t *testing.T) {
+		_, err := gm.Roll(ctx, host, RollOptions{})
+		assert.NoError(t, err)
+		assert.True(t, gm.State().GameInProgress)
+		_, err = gm.VoiceChannels(ctx)
+		assert.ErrorIs(t, err, ErrDiscordUnavailable)
+		assert.NoError(t, gm.Reset(ctx, host))
+
+		settings := gm.State().Settings
+		settings.LaneRoles = true
+		assert.ErrorIs(t, gm.UpdateSettings(ctx, host, settings), ErrDiscordUnavailable)
+	})
+
+	t.Run("Players are removed by hand", func(t *testing.T) {
+		assert.ErrorIs(t, gm.RemoveRosterMember(ctx, host, "ghost"), ErrPlayerNotAvailable)
+		assert.NoError(t, gm.RemoveRosterMember(ctx, host, "manual:bob"))
+		gs := gm.State()
+		assert.NotContains(t, gs.AvailablePlayers, "manual:bob")
+		assert.Equal(t, "", gs.Players[1].Player.ID)
+	})
+
+	t.Run("Discord rosters can not be edited by hand", func(t *testing.T) {
+		gm := NewGameManager(mockDeps, nil, discord.NewRoster(&discordgo.Session{}))
+		assert.ErrorIs(t, gm.AddRosterMember(ctx, host, "", "Alice"), ErrRosterReadOnly)
+	})
+}
+
+func TestGuests(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+	var guestID string
+
+	t.Run("Guests are added from the web client", func(t *testing.T) {
+		_, err := gm.AddGuest(ctx, Actor{}, "Alice")
+		assert.ErrorIs(t, err, ErrForbidden)
+		_, err = gm.AddGuest(ctx, host, " ")
+		assert.ErrorIs(t, err, ErrInvalidContent)
+
+		ap, err := gm.AddGuest(ctx, host, "Alice")
+		assert.NoError(t, err)
+		guestID = *ap.ID
+		assert.True(t, strings.HasPrefix(guestID, guestPrefix))
+		_, err = gm.AddGuest(ctx, host, "alice")
+		assert.ErrorIs(t, err, ErrInvalidContent)
+
+		gs := gm.State()
+		assert.True(t, gs.AvailablePlayers[guestID].Guest)
+		assert.Equal(t, guestID, gs.Players[1].Player.ID)
+		assert.True(t, gs.Players[1].Player.Guest)
+		assert.Equal(t, "player1", gs.HostID)
+
+		var p sharedmodel.Player
+		assert.NoError(t, mockDeps.db.First(&p, "id = ?", guestID).Error)
+		assert.Equal(t, sharedmodel.PlayerKindGuest, p.Kind)
+	})
+
+	t.Run("Guests have their own champion pool", func(t *testing.T) {
+		_, err := gm.AddToGuestPool(ctx, Actor{}, guestID, "Ashe")
+		assert.ErrorIs(t, err, ErrForbidden)
+		_, err = gm.AddToGuestPool(ctx, host, "player1", "Ashe")
+		assert.ErrorIs(t, err, ErrPlayerNotAvailable)
+		_, err = gm.AddToGuestPool(ctx, host, guestID, "Ashe")
+		assert.NoError(t, err)
+		_, err = gm.AddToGuestPool(ctx, host, guestID, "Garen")
+		assert.NoError(t, err)
+		_, err = gm.AddToGuestPool(ctx, host, guestID, "Ryze")
+		assert.NoError(t, err)
+		_, err = gm.RemoveFromGuestPool(ctx, host, guestID, "Ryze")
+		assert.NoError(t, err)
+
+		var count int64
+		mockDeps.db.Model(&sharedmodel.PlayerChampion{}).Where("player_id = ?", guestID).Count(&count)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("Guests are kept when the roster is reloaded", func(t *testing.T) {
+		fakeRoster(gm).Set(roster.Member{ID: "player1", Name: "player1"})
+		gs := gm.State()
+		assert.Contains(t, gs.AvailablePlayers, guestID)
+		assert.Equal(t, guestID, gs.Players[1].Player.ID)
+	})
+
+	t.Run("Guests can not be the host", func(t *testing.T) {
+		assert.ErrorIs(t, gm.TransferHost(ctx, host, guestID), ErrInvalidContent)
+		fakeRoster(gm).Leave("player1")
+		assert.Equal(t, "", gm.State().HostID)
+		fakeRoster(gm).Join(roster.Member{ID: "player1", Name: "player1"})
+		assert.Equal(t, "player1", gm.State().HostID)
+	})
+
+	t.Run("Guests are ready and play with their pool", func(t *testing.T) {
+		assert.NoError(t, gm.StartReadyCheck(ctx, host))
+		gs := gm.State()
+		assert.True(t, gs.ReadyCheck.Ready[guestID])
+		assert.False(t, gs.ReadyCheck.Ready["player1"])
+
+		assert.NoError(t, gm.Ready(ctx, host))
+		gs = gm.State()
+		assert.True(t, gs.GameInProgress)
+		for _, p := range gs.Players {
+			if p.Player.ID == guestID {
+				assert.Contains(t, []string{"Ashe", "Garen"}, p.Champion.Name)
+			}
+		}
+		assert.ErrorIs(t, gm.MergeGuest(ctx, host, guestID, "player1"), ErrGameInProgress)
+		assert.NoError(t, gm.Reset(ctx, host))
+	})
+
+	t.Run("Guests are merged into a discord player", func(t *testing.T) {
+		assert.ErrorIs(t, gm.MergeGuest(ctx, host, guestID, "unknown"), ErrPlayerNotAvailable)
+		bob, err := gm.AddGuest(ctx, host, "Bob")
+		assert.NoError(t, err)
+		assert.ErrorIs(t, gm.MergeGuest(ctx, host, guestID, *bob.ID), ErrInvalidContent)
+		assert.NoError(t, gm.RemoveGuest(ctx, host, *bob.ID))
+
+		// the friend joined the server but is not in the voice channel
+		fakeRoster(gm).Update(roster.Member{ID: "alice", Name: "Alice"})
+		assert.NoError(t, gm.MergeGuest(ctx, host, guestID, "alice"))
+
+		gs := gm.State()
+		assert.NotContains(t, gs.AvailablePlayers, guestID)
+		assert.NotContains(t, gs.AvailablePlayers, "alice")
+		assert.Equal(t, "", gs.Players[1].Player.ID)
+
+		var count int64
+		mockDeps.db.Model(&sharedmodel.Player{}).Where("id = ?", guestID).Count(&count)
+		assert.Equal(t, int64(0), count)
+		mockDeps.db.Model(&sharedmodel.PlayerChampion{}).Where("player_id = ?", "alice").Count(&count)
+		assert.Equal(t, int64(2), count)
+		mockDeps.db.Model(&sharedmodel.GamePlayer{}).Where("player_id = ?", "alice").Count(&count)
+		assert.Equal(t, int64(1), count)
+		mockDeps.db.Model(&sharedmodel.GamePlayerRoll{}).Where("player_id = ?", guestID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("Guests are removed from the lobby", func(t *testing.T) {
+		assert.ErrorIs(t, gm.RemoveGuest(ctx, host, "player1"), ErrPlayerNotAvailable)
+		ap, err := gm.AddGuest(ctx, host, "Bob")
+		assert.NoError(t, err)
+		assert.NoError(t, gm.RemoveGuest(ctx, host, *ap.ID))
+		assert.NotContains(t, gm.State().AvailablePlayers, *ap.ID)
+	})
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	gm, mockDM, mockDeps := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	ctx := context.Background()
+	host := Actor{PlayerID: "player1"}
+
+	t.Run("Concurrent rolls and voice updates", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var rolled atomic.Int32
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if _, err := gm.Roll(ctx, host, RollOptions{}); err != nil {
+					assert.ErrorIs(t, err, ErrCooldownActive)
+					return
+				}
+				rolled.Add(1)
+			}()
+			go func(id string) {
+				defer wg.Done()
+				fakeRoster(gm).Join(roster.Member{ID: id, Name: id})
+			}(fmt.Sprintf("voice%d", i))
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), rolled.Load())
+		gs := gm.State()
+		assert.Equal(t, uint(1), gs.RollCount)
+		assert.Len(t, gs.AvailablePlayers, 12)
+		slotted := 0
+		for _, p := range gs.Players {
+			if p.Player.ID != "" {
+				assert.NotNil(t, p.Champion)
+				slotted++
+			}
+		}
+		var rolls []sharedmodel.GamePlayerRoll
+		mockDeps.db.Find(&rolls, "game_id = ?", gs.GameId)
+		assert.Len(t, rolls, slotted)
+	})
+
+	t.Run("Concurrent cancels and rolls", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, gm.Cancel(ctx, host))
+			}()
+			go func() {
+				defer wg.Done()
+				gm.Roll(ctx, host, RollOptions{})
+			}()
+		}
+		wg.Wait()
+
+		gs := gm.State()
+		var rolls []sharedmodel.GamePlayerRoll
+		mockDeps.db.Find(&rolls, "roll_number > ?", 0)
+		var games []sharedmodel.Game
+		mockDeps.db.Find(&games)
+		if gs.GameInProgress {
+			assert.Equal(t, uint(1), gs.RollCount)
+			assert.Len(t, games, 1)
+			assert.Equal(t, gs.GameId, games[0].ID)
+		} else {
+			assert.Equal(t, uint(0), gs.RollCount)
+			assert.Len(t, games, 0)
+			assert.Len(t, rolls, 0)
+		}
+	})
+}
+
+// connectLobby connects a websocket client to the game manager as the given
+// player, the session is attached like the auth middleware does and the
+// server side reads the messages like the http server does.
+func connectLobby(t *testing.T, gm *gameManager, playerID string) *websocket.Conn {
+	up := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if playerID != "" {
+			r = r.WithContext(auth.WithSession(r.Context(), &auth.Session{PlayerID: playerID}))
+		}
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var hello modelwebsocket.Message
+		if err := conn.ReadJSON(&hello); err != nil {
+			return
+		}
+		if err := gm.HandleWebsocketConnection(conn, r, &hello); err != nil {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "handshake failed"), time.Now().Add(time.Second))
+			return
+		}
+		defer gm.HandleWebsocketDisconnect(conn)
+		for {
+			var wm modelwebsocket.Message
+			if err := conn.ReadJSON(&wm); err != nil {
+				return
+			}
+			gm.HandleWebsocketMessage(&wm, conn, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// dialLobby connects and says hello with every feature of the protocol.
+func dialLobby(t *testing.T, gm *gameManager, playerID string) *websocket.Conn {
+	conn := connectLobby(t, gm, playerID)
+	hello, err := modelwebsocket.NewMessage("hello", modelwebsocket.Hello, modelwebsocket.HelloPayload{
+		ProtocolVersion: modelwebsocket.ProtocolVersion,
+		Features:        modelwebsocket.Features,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, conn.WriteJSON(hello))
+	assert.Equal(t, modelwebsocket.Welcome, readReply(t, conn, "hello").Action)
+	return conn
+}
+
+// readMessage reads the next message with the given action, the other ones
+// are skipped.
+func readMessage(t *testing.T, conn *websocket.Conn, action modelwebsocket.Action) modelwebsocket.Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var m modelwebsocket.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Action == action {
+			return m
+		}
+	}
+}
+
+// readReply skips the state updates until the reply to the given message id.
+func readReply(t *testing.T, conn *websocket.Conn, id string) modelwebsocket.Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var m modelwebsocket.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Action == modelwebsocket.UpdateState || m.Action == modelwebsocket.PatchState {
+			continue
+		}
+		assert.Equal(t, id, m.ID)
+		return m
+	}
+}
+
+func TestWebsocketReplies(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+
+	t.Run("Successful command is acknowledged", func(t *testing.T) {
+		conn := dialLobby(t, gm, "player1")
+		assert.NoError(t, conn.WriteJSON(modelwebsocket.Message{ID: "roll-1", Action: modelwebsocket.Roll}))
+
+		m := readReply(t, conn, "roll-1")
+		assert.Equal(t, modelwebsocket.Ack, m.Action)
+		var p modelwebsocket.AckPayload
+		assert.NoError(t, m.DecodePayload(&p))
+		assert.Equal(t, modelwebsocket.Roll, p.Action)
+	})
+
+	t.Run("Failed command replies with the error code", func(t *testing.T) {
+		conn := dialLobby(t, gm, "player2")
+		assert.NoError(t, conn.WriteJSON(modelwebsocket.Message{ID: "cancel-1", Action: modelwebsocket.Cancel}))
+
+		m := readReply(t, conn, "cancel-1")
+		assert.Equal(t, modelwebsocket.Error, m.Action)
+		var p modelwebsocket.ErrorPayload
+		assert.NoError(t, m.DecodePayload(&p))
+		assert.Equal(t, modelwebsocket.Cancel, p.Action)
+		assert.Equal(t, string(CodeForbidden), p.Code)
+	})
+
+	t.Run("Malformed payload is reported as invalid content", func(t *testing.T) {
+		conn := dialLobby(t, gm, "player1")
+		assert.NoError(t, conn.WriteJSON(modelwebsocket.Message{
+			ID:      "transfer-1",
+			Action:  modelwebsocket.TransferHost,
+			Payload: json.RawMessage(`"player2"`),
+		}))
+
+		m := readReply(t, conn, "transfer-1")
+		var p modelwebsocket.ErrorPayload
+		assert.NoError(t, m.DecodePayload(&p))
+		assert.Equal(t, string(CodeInvalidContent), p.Code)
+		assert.Equal(t, "player1", gm.State().HostID)
+	})
+}
+
+func TestDiffDocuments(t *testing.T) {
+	from := map[string]interface{}{
+		"canRoll": false,
+		"hostId":  "player1",
+		"availablePlayers": map[string]interface{}{
+			"player1": map[string]interface{}{"name": "one"},
+			"a/b":     map[string]interface{}{"name": "slash"},
+		},
+		"players": []interface{}{"player1", ""},
+		"banned":  []interface{}{"1"},
+	}
+	to := map[string]interface{}{
+		"canRoll": true,
+		"hostId":  "player1",
+		"availablePlayers": map[string]interface{}{
+			"player1": map[string]interface{}{"name": "one"},
+			"player2": map[string]interface{}{"name": "two"},
+		},
+		"players": []interface{}{"player1", "player2"},
+		"banned":  []interface{}{"1", "2"},
+	}
+
+	ops, err := diffDocuments("", from, to)
+	assert.NoError(t, err)
+	got := make([]string, 0, len(ops))
+	for _, op := range ops {
+		got = append(got, op.Op+" "+op.Path+" "+string(op.Value))
+	}
+	assert.Equal(t, []string{
+		"remove /availablePlayers/a~1b ",
+		`add /availablePlayers/player2 {"name":"two"}`,
+		`replace /banned ["1","2"]`,
+		"replace /canRoll true",
+		`replace /players/1 "player2"`,
+	}, got)
+
+	ops, err = diffDocuments("", to, to)
+	assert.NoError(t, err)
+	assert.Empty(t, ops)
+}
+
+func TestStatePatches(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	conn := dialLobby(t, gm, "player1")
+
+	var snapshot modelwebsocket.UpdateStatePayload
+	m := readMessage(t, conn, modelwebsocket.UpdateState)
+	assert.NoError(t, m.DecodePayload(&snapshot))
+	assert.Equal(t, "player1", snapshot.State.HostID)
+
+	t.Run("Changes are sent as patches in sequence", func(t *testing.T) {
+		assert.NoError(t, gm.TransferHost(context.Background(), Actor{PlayerID: "player1"}, "player2"))
+
+		var patch modelwebsocket.PatchStatePayload
+		m := readMessage(t, conn, modelwebsocket.PatchState)
+		assert.NoError(t, m.DecodePayload(&patch))
+		assert.Equal(t, snapshot.Seq+1, patch.Seq)
+		assert.Equal(t, []modelwebsocket.PatchOperation{
+			{Op: "replace", Path: "/hostId", Value: json.RawMessage(`"player2"`)},
+		}, patch.Operations)
+	})
+
+	t.Run("Snapshot is sent on request", func(t *testing.T) {
+		assert.NoError(t, conn.WriteJSON(modelwebsocket.Message{ID: "snapshot-1", Action: modelwebsocket.RequestSnapshot}))
+
+		var s modelwebsocket.UpdateStatePayload
+		m := readMessage(t, conn, modelwebsocket.UpdateState)
+		assert.NoError(t, m.DecodePayload(&s))
+		assert.Equal(t, snapshot.Seq+1, s.Seq)
+		assert.Equal(t, "player2", s.State.HostID)
+		assert.Equal(t, modelwebsocket.Ack, readReply(t, conn, "snapshot-1").Action)
+	})
+}
+
+func TestWebsocketHandshake(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+
+	t.Run("Welcome describes the protocol and the lobby", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player2")
+		hello, _ := modelwebsocket.NewMessage("hello", modelwebsocket.Hello, modelwebsocket.HelloPayload{
+			ProtocolVersion: modelwebsocket.ProtocolVersion,
+			Features:        []string{modelwebsocket.FeaturePatchState, "unknownFeature"},
+		})
+		assert.NoError(t, conn.WriteJSON(hello))
+
+		m := readReply(t, conn, "hello")
+		assert.Equal(t, modelwebsocket.Welcome, m.Action)
+		var p modelwebsocket.WelcomePayload
+		assert.NoError(t, m.DecodePayload(&p))
+		assert.Equal(t, modelwebsocket.ProtocolVersion, p.ProtocolVersion)
+		assert.Equal(t, []string{modelwebsocket.FeaturePatchState}, p.Features)
+		assert.Contains(t, p.ClientActions, modelwebsocket.Roll)
+		assert.Contains(t, p.ServerActions, modelwebsocket.PatchState)
+		assert.Equal(t, "test-guild", p.Lobby.GuildID)
+		assert.Equal(t, "player2", p.Lobby.PlayerID)
+		assert.Equal(t, model.LobbyRolePlayer, p.Lobby.Role)
+	})
+
+	t.Run("Clients without patches receive full states", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player2")
+		hello, _ := modelwebsocket.NewMessage("hello", modelwebsocket.Hello, modelwebsocket.HelloPayload{
+			ProtocolVersion: modelwebsocket.ProtocolVersion,
+		})
+		assert.NoError(t, conn.WriteJSON(hello))
+		readMessage(t, conn, modelwebsocket.UpdateState)
+
+		assert.NoError(t, gm.TransferHost(context.Background(), Actor{PlayerID: "player1"}, "player2"))
+
+		var s modelwebsocket.UpdateStatePayload
+		m := readMessage(t, conn, modelwebsocket.UpdateState)
+		assert.NoError(t, m.DecodePayload(&s))
+		assert.Equal(t, "player2", s.State.HostID)
+	})
+
+	t.Run("Oldest supported version is accepted", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player2")
+		hello, _ := modelwebsocket.NewMessage("hello", modelwebsocket.Hello, modelwebsocket.HelloPayload{
+			ProtocolVersion: modelwebsocket.MinProtocolVersion,
+		})
+		assert.NoError(t, conn.WriteJSON(hello))
+		assert.Equal(t, modelwebsocket.Welcome, readReply(t, conn, "hello").Action)
+	})
+
+	for name, hello := range map[string]modelwebsocket.Message{
+		"Unsupported version is rejected": {ID: "hello", Action: modelwebsocket.Hello, Payload: json.RawMessage(`{"protocolVersion":0}`)},
+		"Version below the minimum is rejected": {ID: "hello", Action: modelwebsocket.Hello, Payload: json.RawMessage(
+			fmt.Sprintf(`{"protocolVersion":%d}`, modelwebsocket.MinProtocolVersion-1),
+		)},
+		"Newer version is rejected": {ID: "hello", Action: modelwebsocket.Hello, Payload: json.RawMessage(
+			fmt.Sprintf(`{"protocolVersion":%d}`, modelwebsocket.ProtocolVersion+1),
+		)},
+		"Missing hello is rejected": {ID: "roll", Action: modelwebsocket.Roll},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn := connectLobby(t, gm, "player1")
+			assert.NoError(t, conn.WriteJSON(hello))
+
+			m := readMessage(t, conn, modelwebsocket.Error)
+			assert.Equal(t, hello.ID, m.ID)
+			var p modelwebsocket.ErrorPayload
+			assert.NoError(t, m.DecodePayload(&p))
+			if hello.Action == modelwebsocket.Hello {
+				assert.Equal(t, modelwebsocket.CodeUnsupportedVersion, p.Code)
+			} else {
+				assert.Equal(t, modelwebsocket.CodeHelloRequired, p.Code)
+			}
+
+			_, _, err := conn.ReadMessage()
+			assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+		})
+	}
+}
+
+// clientCount returns the number of registered connections.
+func clientCount(gm *gameManager) int {
+	gm.connsMu.RLock()
+	defer gm.connsMu.RUnlock()
+	return len(gm.conns)
+}
+
+func TestWebsocketClients(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+
+	t.Run("Closed connections are removed", func(t *testing.T) {
+		conn := dialLobby(t, gm, "player1")
+		dialLobby(t, gm, "player2")
+		assert.Equal(t, 2, clientCount(gm))
+
+		conn.Close()
+		assert.Eventually(t, func() bool { return clientCount(gm) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, gm.TransferHost(context.Background(), Actor{PlayerID: "player1"}, "player2"))
+		assert.Equal(t, 1, clientCount(gm))
+	})
+
+	t.Run("Slow clients are disconnected instead of blocking", func(t *testing.T) {
+		c := newClient(connectLobby(t, gm, "player1"), "player1", true, nil)
+		for i := 0; i < sendQueueSize; i++ {
+			c.enqueue(modelwebsocket.Message{Action: modelwebsocket.PatchState})
+		}
+		select {
+		case <-c.done:
+			t.Fatal("client closed before its queue is full")
+		default:
+		}
+
+		c.enqueue(modelwebsocket.Message{Action: modelwebsocket.PatchState})
+		select {
+		case <-c.done:
+			assert.Equal(t, websocket.CloseTryAgainLater, c.closeCode)
+		default:
+			t.Fatal("slow client was not closed")
+		}
+	})
+}
+
+// hello sends a hello with every feature without waiting for the welcome.
+func hello(t *testing.T, conn *websocket.Conn) {
+	m, err := modelwebsocket.NewMessage("hello", modelwebsocket.Hello, modelwebsocket.HelloPayload{
+		ProtocolVersion: modelwebsocket.ProtocolVersion,
+		Features:        modelwebsocket.Features,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, conn.WriteJSON(m))
+}
+
+// watching reports whether a client connected as the player is a viewer.
+func watching(gm *gameManager, playerID string) bool {
+	for _, v := range gm.State().Viewers {
+		if v.PlayerID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWebsocketHeartbeat(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	gm.pingInterval = 50 * time.Millisecond
+
+	t.Run("Viewers are part of the state", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player1")
+		hello(t, conn)
+		assert.Eventually(t, func() bool { return watching(gm, "player1") }, time.Second, 10*time.Millisecond)
+
+		for id, v := range gm.State().Viewers {
+			assert.Equal(t, id, v.ClientID)
+		}
+
+		conn.Close()
+		assert.Eventually(t, func() bool { return !watching(gm, "player1") }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Clients answering pings stay connected", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player2")
+		hello(t, conn)
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		time.Sleep(5 * gm.pingInterval)
+		assert.True(t, watching(gm, "player2"))
+	})
+
+	t.Run("Clients not answering pings are disconnected", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player3")
+		hello(t, conn)
+		assert.Eventually(t, func() bool { return watching(gm, "player3") }, time.Second, 10*time.Millisecond)
+
+		// the client never reads, so it never answers the pings
+		assert.Eventually(t, func() bool { return !watching(gm, "player3") }, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestWebsocketIdleTimeout(t *testing.T) {
+	gm, _, _ := setupTest(t)
+
+	// the idle timeout is several pings long so the pongs keep the
+	// connection alive until then
+	gm.pingInterval = 50 * time.Millisecond
+	gm.idleTimeout = 300 * time.Millisecond
+
+	t.Run("Clients only answering pings are disconnected as idle", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player1")
+		hello(t, conn)
+		start := time.Now()
+
+		// the default ping handler answers every ping while reading
+		var err error
+		for err == nil {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err = conn.ReadMessage()
+		}
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+		assert.GreaterOrEqual(t, time.Since(start), gm.idleTimeout)
+		assert.Eventually(t, func() bool { return !watching(gm, "player1") }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Commands keep the client connected", func(t *testing.T) {
+		conn := connectLobby(t, gm, "player2")
+		hello(t, conn)
+		closed := make(chan error, 1)
+		go func() {
+			for {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, _, err := conn.ReadMessage(); err != nil {
+					closed <- err
+					return
+				}
+			}
+		}()
+		for i := 0; i < 6; i++ {
+			time.Sleep(gm.idleTimeout / 3)
+			assert.NoError(t, conn.WriteJSON(modelwebsocket.Message{ID: fmt.Sprint(i), Action: modelwebsocket.Roll}))
+		}
+		select {
+		case err := <-closed:
+			t.Fatalf("client closed : %v", err)
+		default:
+		}
+		assert.True(t, watching(gm, "player2"))
+
+		err := <-closed
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+	})
+}
+
+// countErrors reads the replies to n messages and counts them by error code.
+func countErrors(t *testing.T, conn *websocket.Conn, n int) map[string]int {
+	codes := map[string]int{}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for n > 0 {
+		var m modelwebsocket.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		switch m.Action {
+		case modelwebsocket.Ack:
+			codes[""]++
+		case modelwebsocket.Error:
+			var p modelwebsocket.ErrorPayload
+			assert.NoError(t, m.DecodePayload(&p))
+			codes[p.Code]++
+		default:
+			continue
+		}
+		n--
+	}
+	return codes
+}
+
+func TestWebsocketRateLimit(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	gm.rateLimit = 1
+	gm.rateBurst = 3
+
+	t.Run("Messages over the burst are rate limited", func(t *testing.T) {
+		conn := dialLobby(t, gm, "player1")
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, conn.WriteJSON(modelwebsocket.Message{Action: modelwebsocket.RequestSnapshot}))
+		}
+
+		codes := countErrors(t, conn, 5)
+		assert.Equal(t, 3, codes[""])
+		assert.Equal(t, 2, codes[modelwebsocket.CodeRateLimited])
+	})
+
+	t.Run("Limits are shared by the connections of a player", func(t *testing.T) {
+		first := dialLobby(t, gm, "player2")
+		second := dialLobby(t, gm, "player2")
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, first.WriteJSON(modelwebsocket.Message{Action: modelwebsocket.RequestSnapshot}))
+		}
+		assert.Equal(t, 2, countErrors(t, first, 2)[""])
+
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, second.WriteJSON(modelwebsocket.Message{Action: modelwebsocket.RequestSnapshot}))
+		}
+		codes := countErrors(t, second, 2)
+		assert.Equal(t, 1, codes[""])
+		assert.Equal(t, 1, codes[modelwebsocket.CodeRateLimited])
+	})
+
+	t.Run("Spamming connections are muted", func(t *testing.T) {
+		l := newLimiter(1, 1)
+		now := time.Now()
+		assert.NoError(t, l.allow(now))
+		var err error
+		for i := 0; i < muteStrikes; i++ {
+			err = l.allow(now)
+		}
+		var ae *admissionError
+		assert.ErrorAs(t, err, &ae)
+		assert.Equal(t, modelwebsocket.CodeMuted, ae.code)
+
+		// the bucket is refilled but the limiter stays muted
+		assert.Error(t, l.allow(now.Add(muteDuration/2)))
+		assert.NoError(t, l.allow(now.Add(muteDuration)))
+	})
+}
+
+// readEvent reads the next server-sent event of the stream, the comments are
+// skipped.
+func readEvent(t *testing.T, r *bufio.Reader) (string, modelwebsocket.Message) {
+	var event string
+	var m modelwebsocket.Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, m
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &m))
+		}
+	}
+}
+
+func TestLobbyReadEndpoints(t *testing.T) {
+	gm, mockDM, _ := setupTest(t)
+	joinLobby(t, gm, mockDM, "player1", "player2")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state/{id}", func(w http.ResponseWriter, r *http.Request) {
+		gm.HandleState(w, r, r.PathValue("id"))
+	})
+	mux.HandleFunc("/events/{id}", func(w http.ResponseWriter, r *http.Request) {
+		gm.HandleEvents(w, r, r.PathValue("id"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	t.Run("Unknown lobbies are not found", func(t *testing.T) {
+		for _, p := range []string{"/state/other", "/events/other"} {
+			res, err := http.Get(srv.URL + p)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		}
+	})
+
+	t.Run("State returns the snapshot", func(t *testing.T) {
+		res, err := http.Get(srv.URL + "/state/test-guild")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var s modelwebsocket.UpdateStatePayload
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&s))
+		assert.Equal(t, "player1", s.State.HostID)
+	})
+
+	t.Run("Events stream the state changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/test-guild", nil)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+		r := bufio.NewReader(res.Body)
+
+		event, m := readEvent(t, r)
+		assert.Equal(t, string(modelwebsocket.UpdateState), event)
+		var s modelwebsocket.UpdateStatePayload
+		assert.NoError(t, m.DecodePayload(&s))
+		assert.Equal(t, "player1", s.State.HostID)
+		assert.Len(t, gm.State().Viewers, 1)
+
+		assert.NoError(t, gm.TransferHost(context.Background(), Actor{PlayerID: "player1"}, "player2"))
+		event, m = readEvent(t, r)
+		assert.Equal(t, string(modelwebsocket.UpdateState), event)
+		assert.NoError(t, m.DecodePayload(&s))
+		assert.Equal(t, "player2", s.State.HostID)
+
+		cancel()
+		res.Body.Close()
+		assert.Eventually(t, func() bool { return len(gm.State().Viewers) == 0 }, 5*time.Second, 10*time.Millisecond)
+	})
+}
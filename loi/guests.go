@@ -0,0 +1,206 @@
+package loi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// guestPrefix is prepended to the random id of the guest players.
+const guestPrefix = "guest:"
+
+// findGuest looks up the player row of a guest.
+func (g *gameManager) findGuest(ctx context.Context, id string) (*sharedmodel.Player, error) {
+	var p sharedmodel.Player
+	err := g.d.Database(ctx).Where("id = ? AND kind = ?", id, sharedmodel.PlayerKindGuest).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w : guest '%s'", ErrPlayerNotAvailable, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// AddGuest adds a player without a discord account to the lobby, a guest with
+// the same name is reused so its champion pool is kept.
+func (g *gameManager) AddGuest(ctx context.Context, actor Actor, name string) (*model.AvailablePlayer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w : the name is empty", ErrInvalidContent)
+	}
+	if err := g.checkEditLobby(ctx, actor); err != nil {
+		return nil, err
+	}
+
+	db := g.d.Database(ctx)
+	var p sharedmodel.Player
+	err := db.Where("LOWER(name) = ? AND kind = ?", strings.ToLower(name), sharedmodel.PlayerKindGuest).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		id := make([]byte, 8)
+		rand.Read(id)
+		p = sharedmodel.Player{
+			ID:   guestPrefix + hex.EncodeToString(id),
+			Name: &name,
+			Kind: sharedmodel.PlayerKindGuest,
+		}
+		err = db.Create(&p).Error
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("[AddGuest] - failed to save guest '%s' : %s", name, err.Error()))
+		return nil, err
+	}
+
+	ap := model.AvailablePlayer{
+		ID:    &p.ID,
+		Name:  p.Name,
+		Guest: true,
+	}
+	slog.Info(fmt.Sprintf("[AddGuest] - '%s' adding guest '%s' (%s)", actor.PlayerID, name, p.ID))
+	return &ap, g.do(ctx, func() error {
+		if _, ok := g.gs.AvailablePlayers[p.ID]; ok {
+			return fmt.Errorf("%w : '%s' is already in the lobby", ErrInvalidContent, name)
+		}
+		g.addAvailablePlayer("[AddGuest]", ap)
+		return nil
+	})
+}
+
+// RemoveGuest implements GameManager.
+func (g *gameManager) RemoveGuest(ctx context.Context, actor Actor, id string) error {
+	return g.do(ctx, func() error {
+		if !g.canEditLobby(actor) {
+			return ErrForbidden
+		}
+		if ap, ok := g.gs.AvailablePlayers[id]; !ok || !ap.Guest {
+			return fmt.Errorf("%w : guest '%s'", ErrPlayerNotAvailable, id)
+		}
+		slog.Info(fmt.Sprintf("[RemoveGuest] - '%s' removing guest '%s'", actor.PlayerID, id))
+		g.removeAvailablePlayer("[RemoveGuest]", id)
+		return nil
+	})
+}
+
+// MergeGuest moves the champion pool and the games of a guest to a discord
+// player, the guest is deleted once merged.
+func (g *gameManager) MergeGuest(ctx context.Context, actor Actor, guestID string, playerID string) error {
+	if err := g.do(ctx, func() error {
+		if !g.canEditLobby(actor) {
+			return ErrForbidden
+		}
+		if g.gs.GameInProgress {
+			return ErrGameInProgress
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if _, err := g.findGuest(ctx, guestID); err != nil {
+		return err
+	}
+	db := g.d.Database(ctx)
+	var p sharedmodel.Player
+	err := db.Where("id = ?", playerID).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w : %s", ErrPlayerNotAvailable, playerID)
+	}
+	if err != nil {
+		return err
+	}
+	if p.Kind == sharedmodel.PlayerKindGuest {
+		return fmt.Errorf("%w : can't merge guest '%s' into another guest", ErrInvalidContent, guestID)
+	}
+
+	slog.Info(fmt.Sprintf("[MergeGuest] - '%s' merging guest '%s' into '%s'", actor.PlayerID, guestID, playerID))
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		pcs := make([]sharedmodel.PlayerChampion, 0)
+		if err := tx.Find(&pcs, "player_id = ?", guestID).Error; err != nil {
+			return err
+		}
+		for i := range pcs {
+			pcs[i].PlayerID = playerID
+		}
+		if len(pcs) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&pcs).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&sharedmodel.PlayerChampion{}, "player_id = ?", guestID).Error; err != nil {
+			return err
+		}
+		// a game with both players keeps the rolls of the discord player
+		both := make([]uint, 0)
+		if err := tx.Model(&sharedmodel.GamePlayer{}).Where("player_id = ?", playerID).Pluck("game_id", &both).Error; err != nil {
+			return err
+		}
+		for _, m := range []interface{}{&sharedmodel.GamePlayer{}, &sharedmodel.GamePlayerRoll{}} {
+			if len(both) > 0 {
+				if err := tx.Where("player_id = ? AND game_id IN ?", guestID, both).Delete(m).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(m).Where("player_id = ?", guestID).Update("player_id", playerID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&sharedmodel.Player{}, "id = ?", guestID).Error
+	}); err != nil {
+		slog.Error(fmt.Sprintf("[MergeGuest] - failed to merge guest '%s' into '%s' : %s", guestID, playerID, err.Error()))
+		return err
+	}
+
+	return g.do(ctx, func() error {
+		if _, ok := g.gs.AvailablePlayers[guestID]; !ok && !g.slotted(guestID) {
+			return nil
+		}
+		delete(g.gs.AvailablePlayers, guestID)
+		ap, available := g.gs.AvailablePlayers[playerID]
+		replace := available && !g.slotted(playerID)
+		for i, gp := range g.gs.Players {
+			if gp.Player.ID != guestID {
+				continue
+			}
+			if replace {
+				g.gs.Players[i].Player = slotPlayer(ap)
+				replace = false
+			} else {
+				g.gs.Players[i] = model.NewEmptyGamePlayer()
+			}
+		}
+		g.electHost()
+
+		g.broadcastState("[MergeGuest]")
+		return nil
+	})
+}
+
+// AddToGuestPool adds a champion to the pool of a guest.
+func (g *gameManager) AddToGuestPool(ctx context.Context, actor Actor, guestID string, champion string) (*model.Champion, error) {
+	if err := g.checkEditLobby(ctx, actor); err != nil {
+		return nil, err
+	}
+	if _, err := g.findGuest(ctx, guestID); err != nil {
+		return nil, err
+	}
+	return g.addToPool(ctx, guestID, champion)
+}
+
+// RemoveFromGuestPool implements GameManager.
+func (g *gameManager) RemoveFromGuestPool(ctx context.Context, actor Actor, guestID string, champion string) (*model.Champion, error) {
+	if err := g.checkEditLobby(ctx, actor); err != nil {
+		return nil, err
+	}
+	if _, err := g.findGuest(ctx, guestID); err != nil {
+		return nil, err
+	}
+	return g.removeFromPool(ctx, guestID, champion)
+}
@@ -0,0 +1,256 @@
+package loi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+)
+
+const (
+	// laneRolePrefix is prepended to the lane in the name of the guild roles.
+	laneRolePrefix = "LOI-"
+	// maxNicknameLength is the longest nickname discord accepts.
+	maxNicknameLength = 32
+)
+
+// laneRoleUpdate gives the players the role of their lane and their champion
+// in their nickname, or removes them when clear is set.
+type laneRoleUpdate struct {
+	guildID   string
+	players   []model.GamePlayer
+	roles     bool
+	nicknames bool
+	clear     bool
+}
+
+// laneRolesEnabled must be called from the game state loop.
+func (g *gameManager) laneRolesEnabled() bool {
+	return g.dm != nil && (g.gs.Settings.LaneRoles || g.gs.Settings.ChampionNicknames)
+}
+
+// checkLaneRolePermissions makes sure the bot can change the roles and the
+// nicknames before the settings are saved. It must be called from the game
+// state loop.
+func (g *gameManager) checkLaneRolePermissions(s model.GameSettings) error {
+	var perms int64
+	if s.LaneRoles {
+		perms |= discordgo.PermissionManageRoles
+	}
+	if s.ChampionNicknames {
+		perms |= discordgo.PermissionManageNicknames
+	}
+	if perms == 0 {
+		return nil
+	}
+	if g.dm == nil {
+		return fmt.Errorf("%w : no discord bot is configured", ErrDiscordUnavailable)
+	}
+	if err := g.dm.CheckPermissions(g.channelID, perms); err != nil {
+		return permissionError(err)
+	}
+	return nil
+}
+
+// queueLaneRoles updates the lane roles without waiting for discord. It must
+// be called from the game state loop.
+func (g *gameManager) queueLaneRoles(clear bool) {
+	u := laneRoleUpdate{
+		guildID:   g.gs.DiscordGuildID,
+		roles:     g.gs.Settings.LaneRoles,
+		nicknames: g.gs.Settings.ChampionNicknames,
+		clear:     clear,
+	}
+	if !clear {
+		u.players = make([]model.GamePlayer, len(g.gs.Players))
+		copy(u.players, g.gs.Players)
+	}
+	g.laneRoleUpdates.push(u)
+}
+
+// applyLaneRoles applies the lane role updates in the order they are queued.
+func (g *gameManager) applyLaneRoles() {
+	g.laneRoleUpdates.run(func(u laneRoleUpdate) {
+		if u.clear {
+			g.clearLaneRoles(u.guildID)
+		} else {
+			g.assignLaneRoles(u)
+		}
+	})
+}
+
+// championNickname adds the champion to the nickname, the name is cut to fit
+// in the discord limit.
+func championNickname(name string, champion string) string {
+	suffix := []rune(fmt.Sprintf(" (%s)", champion))
+	runes := []rune(name)
+	if len(runes)+len(suffix) > maxNicknameLength {
+		keep := maxNicknameLength - len(suffix)
+		if keep < 0 {
+			keep = 0
+		}
+		runes = runes[:keep]
+	}
+	nickname := string(runes) + string(suffix)
+	if len([]rune(nickname)) > maxNicknameLength {
+		nickname = string([]rune(nickname)[:maxNicknameLength])
+	}
+	return nickname
+}
+
+func (g *gameManager) assignLaneRoles(u laneRoleUpdate) {
+	db := g.d.Database(context.Background())
+	roleIDs := map[model.Role]string{}
+	for _, p := range u.players {
+		if p.Player.ID == "" || p.Player.Guest || p.Role == nil || p.Champion == nil {
+			continue
+		}
+		var a sharedmodel.LaneAssignment
+		if err := db.Where(sharedmodel.LaneAssignment{GuildID: u.guildID, UserID: p.Player.ID}).FirstOrInit(&a).Error; err != nil {
+			slog.Error(fmt.Sprintf("[assignLaneRoles] - failed to load the lane assignment of '%s' : %s", p.Player.ID, err.Error()))
+			continue
+		}
+		if u.roles {
+			g.assignLaneRole(&a, *p.Role, roleIDs)
+		}
+		if u.nicknames {
+			g.assignChampionNickname(&a, p.Champion.Name)
+		}
+		if a.RoleID == "" && !a.NicknameChanged {
+			continue
+		}
+		if err := db.Save(&a).Error; err != nil {
+			slog.Error(fmt.Sprintf("[assignLaneRoles] - failed to save the lane assignment of '%s' : %s", p.Player.ID, err.Error()))
+		}
+	}
+}
+
+// assignLaneRole replaces the lane role of the member when a reroll changed
+// its lane.
+func (g *gameManager) assignLaneRole(a *sharedmodel.LaneAssignment, lane model.Role, roleIDs map[model.Role]string) {
+	roleID, ok := roleIDs[lane]
+	if !ok {
+		r, err := g.dm.EnsureRole(a.GuildID, laneRolePrefix+string(lane))
+		if err != nil {
+			slog.Error(fmt.Sprintf("[assignLaneRole] - failed to get the role of lane '%s' : %s", lane, err.Error()))
+			return
+		}
+		roleID = r.ID
+		roleIDs[lane] = roleID
+	}
+	if a.RoleID == roleID {
+		return
+	}
+	if a.RoleID != "" {
+		if err := g.dm.RemoveMemberRole(a.GuildID, a.UserID, a.RoleID); err != nil && !isUnknown(err) {
+			slog.Warn(fmt.Sprintf("[assignLaneRole] - failed to remove the previous lane role of '%s' : %s", a.UserID, err.Error()))
+			return
+		}
+		a.RoleID = ""
+	}
+	if err := g.dm.AddMemberRole(a.GuildID, a.UserID, roleID); err != nil {
+		slog.Warn(fmt.Sprintf("[assignLaneRole] - failed to give the lane role to '%s' : %s", a.UserID, err.Error()))
+		return
+	}
+	a.RoleID = roleID
+}
+
+// assignChampionNickname keeps the nickname the member had before the game so
+// it can be restored.
+func (g *gameManager) assignChampionNickname(a *sharedmodel.LaneAssignment, champion string) {
+	m, err := g.dm.Member(a.GuildID, a.UserID)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[assignChampionNickname] - failed to get member '%s' : %s", a.UserID, err.Error()))
+		return
+	}
+	if !a.NicknameChanged {
+		a.Nickname = m.Nick
+	}
+	name := a.Nickname
+	if name == "" && m.User != nil {
+		name = m.User.GlobalName
+		if name == "" {
+			name = m.User.Username
+		}
+	}
+	nickname := championNickname(name, champion)
+	// the nickname is known before discord sends it back
+	g.setChampionNickname(a.UserID, nickname)
+	if err := g.dm.SetNickname(a.GuildID, a.UserID, nickname); err != nil {
+		slog.Warn(fmt.Sprintf("[assignChampionNickname] - failed to change the nickname of '%s' : %s", a.UserID, err.Error()))
+		g.setChampionNickname(a.UserID, "")
+		return
+	}
+	a.NicknameChanged = true
+}
+
+// setChampionNickname remembers the nickname given to a member, an empty
+// nickname forgets it.
+func (g *gameManager) setChampionNickname(userID string, nickname string) {
+	g.nicknamesMu.Lock()
+	defer g.nicknamesMu.Unlock()
+	if nickname == "" {
+		delete(g.championNicknames, userID)
+	} else {
+		g.championNicknames[userID] = nickname
+	}
+}
+
+// isChampionNickname tells if the name of a member is the nickname given by
+// the lane roles, it is not the name of the player then.
+func (g *gameManager) isChampionNickname(userID string, name string) bool {
+	g.nicknamesMu.Lock()
+	defer g.nicknamesMu.Unlock()
+	nickname, ok := g.championNicknames[userID]
+	return ok && nickname == name
+}
+
+// clearLaneRoles removes every lane role and nickname of the guild, the
+// assignments that could not be removed are kept to be retried on startup.
+func (g *gameManager) clearLaneRoles(guildID string) {
+	db := g.d.Database(context.Background())
+	var as []sharedmodel.LaneAssignment
+	if err := db.Find(&as, "guild_id = ?", guildID).Error; err != nil {
+		slog.Error(fmt.Sprintf("[clearLaneRoles] - failed to load the lane assignments : %s", err.Error()))
+		return
+	}
+	for _, a := range as {
+		if a.RoleID != "" {
+			if err := g.dm.RemoveMemberRole(a.GuildID, a.UserID, a.RoleID); err != nil && !isUnknown(err) {
+				slog.Warn(fmt.Sprintf("[clearLaneRoles] - failed to remove the lane role of '%s' : %s", a.UserID, err.Error()))
+			} else {
+				a.RoleID = ""
+			}
+		}
+		if a.NicknameChanged {
+			if err := g.dm.SetNickname(a.GuildID, a.UserID, a.Nickname); err != nil && !isUnknown(err) {
+				slog.Warn(fmt.Sprintf("[clearLaneRoles] - failed to restore the nickname of '%s' : %s", a.UserID, err.Error()))
+			} else {
+				a.NicknameChanged = false
+				g.setChampionNickname(a.UserID, "")
+			}
+		}
+		var err error
+		if a.RoleID == "" && !a.NicknameChanged {
+			err = db.Delete(&a).Error
+		} else {
+			slog.Warn(fmt.Sprintf("[clearLaneRoles] - keeping the leftovers of '%s' to retry on startup", a.UserID))
+			err = db.Save(&a).Error
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("[clearLaneRoles] - failed to update the lane assignment of '%s' : %s", a.UserID, err.Error()))
+		}
+	}
+}
+
+// isUnknown tells if discord no longer knows the member or the role, there is
+// nothing left to clean up then.
+func isUnknown(err error) bool {
+	var re *discordgo.RESTError
+	return errors.As(err, &re) && re.Response != nil && re.Response.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,110 @@
+package loi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/phturb/bonjack-tools-backend-go/auth"
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+)
+
+// lobbyID is the id of the lobby in the read endpoints, the discord guild or
+// the configured lobby id without discord. It must be called from the game
+// state loop.
+func (g *gameManager) lobbyID() string {
+	if g.dm == nil {
+		return internal.Config().GameManager.LobbyID
+	}
+	return g.gs.DiscordGuildID
+}
+
+// lobbyRole must be called from the game state loop. The admin is always a
+// host, it is the host of the lobbies without discord login.
+func (g *gameManager) lobbyRole(playerID string) model.LobbyRole {
+	if playerID == "" {
+		return model.LobbyRoleSpectator
+	}
+	if playerID == g.gs.HostID || playerID == auth.AdminPlayerID {
+		return model.LobbyRoleHost
+	}
+	for _, p := range g.gs.Players {
+		if p.Player.ID == playerID {
+			return model.LobbyRolePlayer
+		}
+	}
+	return model.LobbyRoleSpectator
+}
+
+// canControl must be called from the game state loop.
+func (g *gameManager) canControl(playerID string) bool {
+	switch g.lobbyRole(playerID) {
+	case model.LobbyRoleHost:
+		return true
+	case model.LobbyRolePlayer:
+		return g.gs.PlayersCanControl
+	default:
+		return false
+	}
+}
+
+// canHost tells if the player is in the lobby and can be its host, guests
+// can't since they never connect. It must be called from the game state loop.
+func (g *gameManager) canHost(playerID string) bool {
+	ap, ok := g.gs.AvailablePlayers[playerID]
+	return ok && playerID != "" && !ap.Guest
+}
+
+// electHost makes sure the host is still in the voice channel, when the
+// host left the role moves to the first player in a slot and then to any
+// available player. It must be called from the game state loop.
+func (g *gameManager) electHost() {
+	if g.canHost(g.gs.HostID) {
+		return
+	}
+	previous := g.gs.HostID
+	g.gs.HostID = ""
+	for _, p := range g.gs.Players {
+		if g.canHost(p.Player.ID) {
+			g.gs.HostID = p.Player.ID
+			break
+		}
+	}
+	if g.gs.HostID == "" {
+		ids := make([]string, 0, len(g.gs.AvailablePlayers))
+		for id := range g.gs.AvailablePlayers {
+			if g.canHost(id) {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		if len(ids) > 0 {
+			g.gs.HostID = ids[0]
+		}
+	}
+	if previous != g.gs.HostID {
+		slog.Info(fmt.Sprintf("[electHost] - host moved from '%s' to '%s'", previous, g.gs.HostID))
+	}
+}
+
+// TransferHost implements GameManager.
+func (g *gameManager) TransferHost(ctx context.Context, actor Actor, playerID string) error {
+	return g.do(ctx, func() error {
+		if g.lobbyRole(actor.PlayerID) != model.LobbyRoleHost {
+			return ErrForbidden
+		}
+		if _, ok := g.gs.AvailablePlayers[playerID]; !ok || playerID == "" {
+			return fmt.Errorf("%w : %s", ErrPlayerNotAvailable, playerID)
+		}
+		if !g.canHost(playerID) {
+			return fmt.Errorf("%w : guest '%s' can't be the host", ErrInvalidContent, playerID)
+		}
+		slog.Info(fmt.Sprintf("[TransferHost] - host moved from '%s' to '%s'", g.gs.HostID, playerID))
+		g.gs.HostID = playerID
+
+		g.broadcastState("[TransferHost]")
+		return nil
+	})
+}
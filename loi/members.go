@@ -0,0 +1,303 @@
+package loi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+	"github.com/phturb/bonjack-tools-backend-go/roster"
+	"gorm.io/gorm/clause"
+)
+
+// manualMemberPrefix is prepended to the name of the members added by hand
+// without id.
+const manualMemberPrefix = "manual:"
+
+var _ roster.Events = (*gameManager)(nil)
+
+func availablePlayer(m roster.Member) model.AvailablePlayer {
+	id := m.ID
+	name := m.Name
+	return model.AvailablePlayer{
+		ID:     &id,
+		Name:   &name,
+		Avatar: m.Avatar,
+	}
+}
+
+// memberPlayer is the available player of a member, the champion nickname
+// given by the lane roles is replaced by the saved name of the player.
+func (g *gameManager) memberPlayer(ctx context.Context, m roster.Member) model.AvailablePlayer {
+	ap := availablePlayer(m)
+	if !g.isChampionNickname(m.ID, m.Name) {
+		return ap
+	}
+	var p sharedmodel.Player
+	if err := g.d.Database(ctx).Select("name").First(&p, "id = ?", m.ID).Error; err != nil {
+		slog.Warn(fmt.Sprintf("[memberPlayer] - failed to load the name of '%s' : %s", m.ID, err.Error()))
+		return ap
+	}
+	if p.Name != nil {
+		ap.Name = p.Name
+	}
+	return ap
+}
+
+// savePlayer keeps the player row of the member up to date.
+func (g *gameManager) savePlayer(ctx context.Context, ap model.AvailablePlayer) {
+	if err := g.d.Database(ctx).Model(&sharedmodel.Player{}).Clauses(clause.OnConflict{
+		UpdateAll: true,
+	}).Create(&sharedmodel.Player{
+		ID:     *ap.ID,
+		Name:   ap.Name,
+		Avatar: ap.Avatar,
+	}).Error; err != nil {
+		slog.Warn(fmt.Sprintf("failed to update player '%s' in database : %s", *ap.ID, err.Error()))
+	}
+}
+
+// renamePlayer updates the name and the avatar of the member in the lobby and
+// in its slot, it returns false when the member is not in the lobby. It must
+// be called from the game state loop.
+func (g *gameManager) renamePlayer(ap model.AvailablePlayer) bool {
+	changed := false
+	if _, ok := g.gs.AvailablePlayers[*ap.ID]; ok {
+		g.gs.AvailablePlayers[*ap.ID] = ap
+		changed = true
+	}
+	for i := range g.gs.Players {
+		if g.gs.Players[i].Player.ID != *ap.ID {
+			continue
+		}
+		g.gs.Players[i].Player.Name = ap.Name
+		g.gs.Players[i].Player.Avatar = ap.Avatar
+		changed = true
+	}
+	return changed
+}
+
+// updateLobby tells the roster which voice channels are part of the lobby. It
+// must be called from the game state loop.
+func (g *gameManager) updateLobby() {
+	l, ok := g.roster.(roster.Lobby)
+	if !ok {
+		return
+	}
+	ids := []string{g.channelID}
+	for id := range g.teamChannels {
+		ids = append(ids, id)
+	}
+	l.SetLobby(ids)
+}
+
+// slotted must be called from the game state loop.
+func (g *gameManager) slotted(id string) bool {
+	for _, p := range g.gs.Players {
+		if p.Player.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RosterSet implements roster.Events.
+func (g *gameManager) RosterSet(members []roster.Member) {
+	ctx := context.Background()
+	aps := make(map[string]model.AvailablePlayer)
+	for _, m := range members {
+		if m.ID == "" {
+			slog.Warn("[RosterSet] - member is missing its id")
+			continue
+		}
+		ap := g.memberPlayer(ctx, m)
+		aps[*ap.ID] = ap
+		g.savePlayer(ctx, ap)
+	}
+
+	g.do(ctx, func() error {
+		// the guests are not part of the roster
+		for id, ap := range g.gs.AvailablePlayers {
+			if ap.Guest {
+				aps[id] = ap
+			}
+		}
+		g.gs.AvailablePlayers = aps
+		if !g.gs.GameInProgress {
+			for i, p := range g.gs.Players {
+				if p.Player.ID == "" {
+					continue
+				}
+				if ap, ok := aps[p.Player.ID]; !ok {
+					slog.Warn(fmt.Sprintf("player not found '%s' in game state", p.Player.ID))
+					g.gs.Players[i] = model.NewEmptyGamePlayer()
+				} else {
+					g.gs.Players[i].Player.ID = *ap.ID
+					g.gs.Players[i].Player.Name = ap.Name
+					g.gs.Players[i].Player.Avatar = ap.Avatar
+				}
+			}
+			for len(g.gs.Players) < g.gs.Settings.SlotCount() {
+				g.gs.Players = append(g.gs.Players, model.NewEmptyGamePlayer())
+			}
+		}
+		g.electHost()
+
+		g.broadcastState("[RosterSet]")
+		return nil
+	})
+}
+
+// MemberJoined implements roster.Events, the member takes the first free
+// slot when no game is in progress.
+func (g *gameManager) MemberJoined(m roster.Member) {
+	ctx := context.Background()
+	ap := g.memberPlayer(ctx, m)
+	g.savePlayer(ctx, ap)
+	g.do(ctx, func() error {
+		g.addAvailablePlayer("[MemberJoined]", ap)
+		return nil
+	})
+}
+
+// addAvailablePlayer adds the player to the lobby, it takes the first free
+// slot when no game is in progress. It must be called from the game state
+// loop.
+func (g *gameManager) addAvailablePlayer(prefix string, ap model.AvailablePlayer) {
+	slog.Info(fmt.Sprintf("%s - adding player with id %s and name %s to available players", prefix, *ap.ID, *ap.Name))
+	g.gs.AvailablePlayers[*ap.ID] = ap
+	g.renamePlayer(ap)
+	if !g.gs.GameInProgress && !g.slotted(*ap.ID) {
+		for i := range g.gs.Players {
+			if g.gs.Players[i].Player.ID == "" {
+				slog.Info(fmt.Sprintf("%s - adding player with id %s and name %s to current players slot %d", prefix, *ap.ID, *ap.Name, i))
+				g.gs.Players[i].Player = slotPlayer(ap)
+				break
+			}
+		}
+	}
+	g.electHost()
+
+	g.broadcastState(prefix)
+}
+
+// slotPlayer is the player of a slot taken by an available player.
+func slotPlayer(ap model.AvailablePlayer) model.DiscordPlayer {
+	return model.DiscordPlayer{
+		ID:     *ap.ID,
+		Name:   ap.Name,
+		Avatar: ap.Avatar,
+		Guest:  ap.Guest,
+	}
+}
+
+// MemberUpdated implements roster.Events.
+func (g *gameManager) MemberUpdated(m roster.Member) {
+	ctx := context.Background()
+	ap := g.memberPlayer(ctx, m)
+	g.savePlayer(ctx, ap)
+	g.do(ctx, func() error {
+		if g.renamePlayer(ap) {
+			g.broadcastState("[MemberUpdated]")
+		}
+		return nil
+	})
+}
+
+// MemberLeft implements roster.Events, the slot of the member is freed when
+// no game is in progress.
+func (g *gameManager) MemberLeft(id string) {
+	g.do(context.Background(), func() error {
+		if _, ok := g.gs.AvailablePlayers[id]; !ok && !g.slotted(id) {
+			return nil
+		}
+		g.removeAvailablePlayer("[MemberLeft]", id)
+		return nil
+	})
+}
+
+// removeAvailablePlayer removes the player from the lobby, its slot is freed
+// when no game is in progress. It must be called from the game state loop.
+func (g *gameManager) removeAvailablePlayer(prefix string, id string) {
+	if !g.gs.GameInProgress {
+		for i, p := range g.gs.Players {
+			if p.Player.ID == id {
+				g.gs.Players[i] = model.NewEmptyGamePlayer()
+				slog.Warn(fmt.Sprintf("%s - removing player id %s from game state", prefix, p.Player.ID))
+			}
+		}
+	}
+	slog.Warn(fmt.Sprintf("%s - removing player with id %s from available players", prefix, id))
+	delete(g.gs.AvailablePlayers, id)
+	g.electHost()
+
+	g.broadcastState(prefix)
+}
+
+// canEditLobby tells if the actor can add players by hand, anyone logged in
+// can when there is no host yet. It must be called from the game state loop.
+func (g *gameManager) canEditLobby(actor Actor) bool {
+	return actor.PlayerID != "" && (g.gs.HostID == "" || g.canControl(actor.PlayerID))
+}
+
+// editableRoster returns the roster when its members are added by hand.
+func (g *gameManager) editableRoster(ctx context.Context, actor Actor) (roster.Editable, error) {
+	r, ok := g.roster.(roster.Editable)
+	if !ok {
+		return nil, ErrRosterReadOnly
+	}
+	return r, g.checkEditLobby(ctx, actor)
+}
+
+// checkEditLobby returns ErrForbidden when the actor can't add players by
+// hand.
+func (g *gameManager) checkEditLobby(ctx context.Context, actor Actor) error {
+	return g.do(ctx, func() error {
+		if !g.canEditLobby(actor) {
+			return ErrForbidden
+		}
+		return nil
+	})
+}
+
+// AddRosterMember implements GameManager.
+func (g *gameManager) AddRosterMember(ctx context.Context, actor Actor, id string, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("%w : the name is empty", ErrInvalidContent)
+	}
+	if id == "" {
+		id = manualMemberPrefix + strings.ToLower(name)
+	}
+	r, err := g.editableRoster(ctx, actor)
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("[AddRosterMember] - '%s' adding '%s' (%s)", actor.PlayerID, name, id))
+	if err := r.Add(ctx, roster.Member{ID: id, Name: name}); err != nil {
+		if errors.Is(err, roster.ErrMemberExists) {
+			return fmt.Errorf("%w : '%s' is already in the lobby", ErrInvalidContent, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveRosterMember implements GameManager.
+func (g *gameManager) RemoveRosterMember(ctx context.Context, actor Actor, id string) error {
+	r, err := g.editableRoster(ctx, actor)
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("[RemoveRosterMember] - '%s' removing '%s'", actor.PlayerID, id))
+	if err := r.Remove(ctx, id); err != nil {
+		if errors.Is(err, roster.ErrMemberNotFound) {
+			return fmt.Errorf("%w : '%s'", ErrPlayerNotAvailable, id)
+		}
+		return err
+	}
+	return nil
+}
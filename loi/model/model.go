@@ -2,6 +2,7 @@ package model
 
 import (
 	"math/rand"
+	"time"
 
 	dbmodel "github.com/phturb/bonjack-tools-backend-go/model"
 )
@@ -9,6 +10,9 @@ import (
 type DiscordPlayer struct {
 	ID   string  `json:"id"`
 	Name *string `json:"name"`
+	// Avatar is the discord avatar hash of the user.
+	Avatar *string `json:"avatar,omitempty"`
+	Guest  bool    `json:"guest,omitempty"`
 }
 
 func NewEmptyDiscordPlayer() DiscordPlayer {
@@ -53,6 +57,9 @@ type GamePlayer struct {
 	Player   DiscordPlayer `json:"player"`
 	Role     *Role         `json:"role"`
 	Champion *Champion     `json:"champion"`
+	// Locked keeps the role and the champion of the player on the next rolls
+	// of the game.
+	Locked bool `json:"locked"`
 }
 
 type Champion struct {
@@ -77,14 +84,160 @@ func NewEmptyGamePlayer() GamePlayer {
 		Player:   NewEmptyDiscordPlayer(),
 		Role:     nil,
 		Champion: nil,
+		Locked:   false,
 	}
 }
 
-type AvailablePlayer struct {
-	ID   *string `json:"id"`
+// PlayerSlot is a requested player for a slot, the name is only informative
+// since the available player name is used.
+type PlayerSlot struct {
+	ID   string  `json:"id"`
 	Name *string `json:"name,omitempty"`
 }
 
+type AvailablePlayer struct {
+	ID     *string `json:"id"`
+	Name   *string `json:"name,omitempty"`
+	Avatar *string `json:"avatar,omitempty"`
+	// Guest is set for the players without discord account, they stay in
+	// the lobby until removed.
+	Guest bool `json:"guest,omitempty"`
+}
+
+type RollStrategy string
+
+const (
+	// RollStrategyRandom rolls new roles and champions on every roll.
+	RollStrategyRandom RollStrategy = "random"
+	// RollStrategyKeepRoles keeps the roles of the first roll and only
+	// rerolls the champions.
+	RollStrategyKeepRoles RollStrategy = "keepRoles"
+)
+
+func (rs RollStrategy) Valid() bool {
+	switch rs {
+	case RollStrategyRandom, RollStrategyKeepRoles:
+		return true
+	}
+	return false
+}
+
+// TeamSize is the number of slots of a team, one for each role.
+const TeamSize = 5
+
+// TeamOf returns the team of a slot, starting at 0.
+func TeamOf(slot int) int {
+	return slot / TeamSize
+}
+
+type GameSettings struct {
+	// Cooldown between two rolls in milliseconds.
+	Cooldown uint `json:"cooldown"`
+	// MaxRerolls is the number of rolls allowed after the first one, 0 means
+	// there is no limit.
+	MaxRerolls            uint         `json:"maxRerolls"`
+	RollStrategy          RollStrategy `json:"rollStrategy"`
+	IncludeWeeklyRotation bool         `json:"includeWeeklyRotation"`
+	BannedChampionIDs     []string     `json:"bannedChampionIds"`
+	// Teams is the number of teams of the game, 1 or 2.
+	Teams uint `json:"teams"`
+	// MoveToTeamChannels moves the players of a two teams game in the voice
+	// channel of their team once the game starts.
+	MoveToTeamChannels bool `json:"moveToTeamChannels"`
+	// LaneRoles gives the players a guild role named after their lane
+	// during the game.
+	LaneRoles bool `json:"laneRoles"`
+	// ChampionNicknames adds the rolled champion to the nickname of the
+	// players during the game.
+	ChampionNicknames bool `json:"championNicknames"`
+}
+
+func NewDefaultGameSettings(cooldown uint) GameSettings {
+	return GameSettings{
+		Cooldown:              cooldown,
+		MaxRerolls:            0,
+		RollStrategy:          RollStrategyRandom,
+		IncludeWeeklyRotation: true,
+		BannedChampionIDs:     []string{},
+		Teams:                 1,
+		MoveToTeamChannels:    false,
+		LaneRoles:             false,
+		ChampionNicknames:     false,
+	}
+}
+
+// SlotCount is the number of player slots of the game.
+func (gs GameSettings) SlotCount() int {
+	if gs.Teams == 0 {
+		return TeamSize
+	}
+	return int(gs.Teams) * TeamSize
+}
+
+func (gs GameSettings) IsBanned(championID string) bool {
+	for _, id := range gs.BannedChampionIDs {
+		if id == championID {
+			return true
+		}
+	}
+	return false
+}
+
+func GameSettingsToDB(gs GameSettings) dbmodel.GameSettings {
+	return dbmodel.GameSettings{
+		Cooldown:              gs.Cooldown,
+		MaxRerolls:            gs.MaxRerolls,
+		RollStrategy:          string(gs.RollStrategy),
+		IncludeWeeklyRotation: gs.IncludeWeeklyRotation,
+		BannedChampionIDs:     gs.BannedChampionIDs,
+		Teams:                 gs.Teams,
+		MoveToTeamChannels:    gs.MoveToTeamChannels,
+		LaneRoles:             gs.LaneRoles,
+		ChampionNicknames:     gs.ChampionNicknames,
+	}
+}
+
+type ReadyCheck struct {
+	Active      bool   `json:"active"`
+	RequestedBy string `json:"requestedBy"`
+	// RemainingTime before the players who did not answer are removed, in
+	// milliseconds.
+	RemainingTime uint            `json:"remainingTime"`
+	Ready         map[string]bool `json:"ready"`
+}
+
+func NewEmptyReadyCheck() ReadyCheck {
+	return ReadyCheck{
+		Active:        false,
+		RequestedBy:   "",
+		RemainingTime: 0,
+		Ready:         make(map[string]bool),
+	}
+}
+
+type LobbyRole string
+
+const (
+	LobbyRoleHost      LobbyRole = "host"
+	LobbyRolePlayer    LobbyRole = "player"
+	LobbyRoleSpectator LobbyRole = "spectator"
+)
+
+// Viewer is a web client watching the lobby, the player id is the discord
+// user the client is connected as.
+type Viewer struct {
+	ClientID    string    `json:"clientId"`
+	PlayerID    string    `json:"playerId,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// VoiceChannel is a voice channel of the guild the lobby can track.
+type VoiceChannel struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+}
+
 type GameState struct {
 	Players                 []GamePlayer               `json:"players"`
 	RollCount               uint                       `json:"rollCount"`
@@ -98,9 +251,15 @@ type GameState struct {
 	DiscordGuildChannelID   string                     `json:"discordGuildChannelId"`
 	DiscordGuildChannelName string                     `json:"discordGuildChannel"`
 	LeagueVersion           string                     `json:"leagueVersion"`
+	HostID                  string                     `json:"hostId"`
+	PlayersCanControl       bool                       `json:"playersCanControl"`
+	Settings                GameSettings               `json:"settings"`
+	ReadyCheck              ReadyCheck                 `json:"readyCheck"`
+	TimerPaused             bool                       `json:"timerPaused"`
+	Viewers                 map[string]Viewer          `json:"viewers"`
 }
 
-func NewDefaultGameState() GameState {
+func NewDefaultGameState(settings GameSettings) GameState {
 	return GameState{
 		Players: []GamePlayer{
 			NewEmptyGamePlayer(),
@@ -120,5 +279,34 @@ func NewDefaultGameState() GameState {
 		DiscordGuildChannelName: "",
 		DiscordGuildChannelID:   "",
 		LeagueVersion:           "",
+		HostID:                  "",
+		PlayersCanControl:       false,
+		Settings:                settings,
+		ReadyCheck:              NewEmptyReadyCheck(),
+		TimerPaused:             false,
+		Viewers:                 make(map[string]Viewer),
+	}
+}
+
+// Clone returns a copy of the game state that can be read outside of the game
+// state loop.
+func (gs GameState) Clone() GameState {
+	c := gs
+	c.Players = make([]GamePlayer, len(gs.Players))
+	copy(c.Players, gs.Players)
+	c.AvailablePlayers = make(map[string]AvailablePlayer, len(gs.AvailablePlayers))
+	for id, ap := range gs.AvailablePlayers {
+		c.AvailablePlayers[id] = ap
+	}
+	c.Settings.BannedChampionIDs = make([]string, len(gs.Settings.BannedChampionIDs))
+	copy(c.Settings.BannedChampionIDs, gs.Settings.BannedChampionIDs)
+	c.ReadyCheck.Ready = make(map[string]bool, len(gs.ReadyCheck.Ready))
+	for id, r := range gs.ReadyCheck.Ready {
+		c.ReadyCheck.Ready[id] = r
+	}
+	c.Viewers = make(map[string]Viewer, len(gs.Viewers))
+	for id, v := range gs.Viewers {
+		c.Viewers[id] = v
 	}
+	return c
 }
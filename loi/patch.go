@@ -0,0 +1,105 @@
+package loi
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+)
+
+// toDocument converts a value to its generic JSON representation so two
+// versions of it can be compared.
+func toDocument(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// diffDocuments returns the JSON Patch operations turning from into to.
+// Objects are compared key by key and arrays of the same length index by
+// index, anything else that changed is replaced as a whole.
+func diffDocuments(path string, from, to interface{}) ([]modelwebsocket.PatchOperation, error) {
+	var ops []modelwebsocket.PatchOperation
+	switch f := from.(type) {
+	case map[string]interface{}:
+		t, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(f)+len(t))
+		for k := range f {
+			keys = append(keys, k)
+		}
+		for k := range t {
+			if _, ok := f[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + "/" + escapePointer(k)
+			fv, inFrom := f[k]
+			tv, inTo := t[k]
+			switch {
+			case !inTo:
+				ops = append(ops, modelwebsocket.PatchOperation{Op: "remove", Path: p})
+			case !inFrom:
+				op, err := patchOperation("add", p, tv)
+				if err != nil {
+					return nil, err
+				}
+				ops = append(ops, op)
+			default:
+				sub, err := diffDocuments(p, fv, tv)
+				if err != nil {
+					return nil, err
+				}
+				ops = append(ops, sub...)
+			}
+		}
+		return ops, nil
+	case []interface{}:
+		t, ok := to.([]interface{})
+		if !ok || len(f) != len(t) {
+			break
+		}
+		for i := range f {
+			sub, err := diffDocuments(path+"/"+strconv.Itoa(i), f[i], t[i])
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, sub...)
+		}
+		return ops, nil
+	}
+	if reflect.DeepEqual(from, to) {
+		return nil, nil
+	}
+	op, err := patchOperation("replace", path, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, op), nil
+}
+
+func patchOperation(op string, path string, v interface{}) (modelwebsocket.PatchOperation, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return modelwebsocket.PatchOperation{}, err
+	}
+	return modelwebsocket.PatchOperation{Op: op, Path: path, Value: b}, nil
+}
+
+// escapePointer escapes a key for a JSON Pointer (RFC 6901).
+func escapePointer(k string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+}
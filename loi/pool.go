@@ -0,0 +1,79 @@
+package loi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// findChampion looks up a champion by its name, ignoring the case.
+func (g *gameManager) findChampion(ctx context.Context, name string) (*sharedmodel.Champion, error) {
+	var c sharedmodel.Champion
+	err := g.d.Database(ctx).Where("LOWER(name) = ?", strings.ToLower(strings.TrimSpace(name))).First(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w : '%s'", ErrChampionNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// AddToPool adds a champion to the pool of the actor, the champions of a
+// player are rolled instead of every champion once the pool is not empty.
+func (g *gameManager) AddToPool(ctx context.Context, actor Actor, champion string) (*model.Champion, error) {
+	if actor.PlayerID == "" {
+		return nil, ErrForbidden
+	}
+	return g.addToPool(ctx, actor.PlayerID, champion)
+}
+
+// RemoveFromPool implements GameManager.
+func (g *gameManager) RemoveFromPool(ctx context.Context, actor Actor, champion string) (*model.Champion, error) {
+	if actor.PlayerID == "" {
+		return nil, ErrForbidden
+	}
+	return g.removeFromPool(ctx, actor.PlayerID, champion)
+}
+
+// addToPool adds a champion to the pool of the player, the player is created
+// when it is not known yet.
+func (g *gameManager) addToPool(ctx context.Context, playerID string, champion string) (*model.Champion, error) {
+	c, err := g.findChampion(ctx, champion)
+	if err != nil {
+		return nil, err
+	}
+	db := g.d.Database(ctx)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&sharedmodel.Player{ID: playerID}).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&sharedmodel.PlayerChampion{
+		PlayerID:   playerID,
+		ChampionID: c.ID,
+	}).Error; err != nil {
+		slog.Error(fmt.Sprintf("[addToPool] - failed to add champion '%s' to the pool of '%s' : %s", c.ID, playerID, err.Error()))
+		return nil, err
+	}
+	slog.Info(fmt.Sprintf("[addToPool] - champion '%s' added to the pool of '%s'", c.Name, playerID))
+	return model.ChampionFromDB(c), nil
+}
+
+func (g *gameManager) removeFromPool(ctx context.Context, playerID string, champion string) (*model.Champion, error) {
+	c, err := g.findChampion(ctx, champion)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.d.Database(ctx).Delete(&sharedmodel.PlayerChampion{}, "player_id = ? AND champion_id = ?", playerID, c.ID).Error; err != nil {
+		slog.Error(fmt.Sprintf("[removeFromPool] - failed to remove champion '%s' from the pool of '%s' : %s", c.ID, playerID, err.Error()))
+		return nil, err
+	}
+	slog.Info(fmt.Sprintf("[removeFromPool] - champion '%s' removed from the pool of '%s'", c.Name, playerID))
+	return model.ChampionFromDB(c), nil
+}
@@ -0,0 +1,75 @@
+package loi
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// jobQueue holds the discord side effects waiting for their worker without
+// ever blocking the game state loop. A job is only removed from the queue when
+// a newer job makes it useless, so the jobs closing a game are never dropped.
+type jobQueue[T any] struct {
+	// name is used in the logs.
+	name string
+	// supersedes tells if a new job makes a waiting job useless.
+	supersedes func(job T, waiting T) bool
+
+	mu   sync.Mutex
+	jobs []T
+	// ready is signaled when a job is pushed.
+	ready chan struct{}
+}
+
+func newJobQueue[T any](name string, supersedes func(job T, waiting T) bool) *jobQueue[T] {
+	return &jobQueue[T]{
+		name:       name,
+		supersedes: supersedes,
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// push queues the job without waiting for the worker.
+func (q *jobQueue[T]) push(job T) {
+	q.mu.Lock()
+	jobs := q.jobs[:0]
+	for _, w := range q.jobs {
+		if !q.supersedes(job, w) {
+			jobs = append(jobs, w)
+		}
+	}
+	if n := len(q.jobs) - len(jobs); n > 0 {
+		slog.Warn(fmt.Sprintf("[push] - discord is behind, %d waiting %s are replaced", n, q.name))
+	}
+	q.jobs = append(jobs, job)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *jobQueue[T]) pop() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var job T
+	if len(q.jobs) == 0 {
+		return job, false
+	}
+	job = q.jobs[0]
+	q.jobs = q.jobs[1:]
+	return job, true
+}
+
+// run applies the jobs in the order they are queued, it never returns.
+func (q *jobQueue[T]) run(fn func(T)) {
+	for range q.ready {
+		for {
+			job, ok := q.pop()
+			if !ok {
+				break
+			}
+			fn(job)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package loi
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+)
+
+const (
+	// maxConcurrentHandlers caps the websocket commands running at once.
+	maxConcurrentHandlers = 32
+	// muteStrikes rate limited messages in a row mute the connection for
+	// muteDuration, every message is rejected while muted.
+	muteStrikes  = 10
+	muteDuration = 30 * time.Second
+	// identityLimiterTTL is the time an unused identity limiter is kept.
+	identityLimiterTTL = time.Minute
+)
+
+// limiter is a token bucket refilled at rate tokens per second up to burst.
+type limiter struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	strikes  int
+	mutedTil time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token, rejected messages count as strikes and enough strikes
+// in a row mute the limiter.
+func (l *limiter) allow(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.mutedTil) {
+		return &admissionError{code: modelwebsocket.CodeMuted, message: fmt.Sprintf("muted for %s", l.mutedTil.Sub(now).Round(time.Second))}
+	}
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		l.strikes++
+		if l.strikes >= muteStrikes {
+			l.strikes = 0
+			l.mutedTil = now.Add(muteDuration)
+			return &admissionError{code: modelwebsocket.CodeMuted, message: fmt.Sprintf("too many messages, muted for %s", muteDuration)}
+		}
+		return &admissionError{code: modelwebsocket.CodeRateLimited, message: "too many messages, slow down"}
+	}
+	l.tokens--
+	l.strikes = 0
+	return nil
+}
+
+func (l *limiter) unusedSince(t time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last.Before(t) && !l.mutedTil.After(t)
+}
+
+// admissionError is the reason a websocket message is refused before it
+// reaches the game commands.
+type admissionError struct {
+	code    string
+	message string
+}
+
+func (e *admissionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.code, e.message)
+}
+
+// admit applies the limits of the connection and of the player behind it, a
+// nil client is only limited by its identity.
+func (g *gameManager) admit(c *client, playerID string) error {
+	now := time.Now()
+	if c != nil {
+		if err := c.limiter.allow(now); err != nil {
+			return err
+		}
+	}
+	if playerID == "" {
+		return nil
+	}
+	g.limitersMu.Lock()
+	l, ok := g.limiters[playerID]
+	if !ok {
+		l = newLimiter(g.rateLimit, g.rateBurst)
+		g.limiters[playerID] = l
+	}
+	g.limitersMu.Unlock()
+	return l.allow(now)
+}
+
+// pruneLimiters forgets the identity limiters that are not used anymore.
+func (g *gameManager) pruneLimiters() {
+	t := time.Now().Add(-identityLimiterTTL)
+	g.limitersMu.Lock()
+	defer g.limitersMu.Unlock()
+	for id, l := range g.limiters {
+		if l.unusedSince(t) {
+			delete(g.limiters, id)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package loi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+)
+
+// StartReadyCheck implements GameManager.
+func (g *gameManager) StartReadyCheck(ctx context.Context, actor Actor) error {
+	return g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
+		}
+		if g.gs.ReadyCheck.Active {
+			return ErrReadyCheckInProgress
+		}
+		if g.gs.TimerPaused {
+			return ErrTimerPaused
+		}
+		if !g.gs.CanRoll {
+			return ErrCooldownActive
+		}
+		rc := model.NewEmptyReadyCheck()
+		rc.Active = true
+		rc.RequestedBy = actor.PlayerID
+		rc.RemainingTime = internal.Config().GameManager.ReadyCheckTime
+		for _, p := range g.gs.Players {
+			if p.Player.ID == "" {
+				continue
+			}
+			// guests can't answer, they are ready from the start
+			rc.Ready[p.Player.ID] = p.Player.Guest
+		}
+		if len(rc.Ready) == 0 {
+			return ErrNoPlayers
+		}
+		slog.Info(fmt.Sprintf("[StartReadyCheck] - starting ready check for %d players", len(rc.Ready)))
+		g.gs.ReadyCheck = rc
+		g.pingUnready("Ready check started, answer with ready in the LoI lobby")
+
+		g.broadcastState("[StartReadyCheck]")
+		return nil
+	})
+}
+
+// Ready implements GameManager.
+func (g *gameManager) Ready(ctx context.Context, actor Actor) error {
+	return g.do(ctx, func() error {
+		if !g.gs.ReadyCheck.Active {
+			return ErrNoReadyCheck
+		}
+		if _, ok := g.gs.ReadyCheck.Ready[actor.PlayerID]; !ok {
+			return ErrNotInReadyCheck
+		}
+		slog.Info(fmt.Sprintf("[Ready] - player '%s' is ready", actor.PlayerID))
+		g.gs.ReadyCheck.Ready[actor.PlayerID] = true
+		for id, ready := range g.gs.ReadyCheck.Ready {
+			if !ready && g.inSlot(id) {
+				g.broadcastState("[Ready]")
+				return nil
+			}
+		}
+		g.finishReadyCheck(ctx)
+		return nil
+	})
+}
+
+// tickReadyCheck counts down the ready check, players are reminded
+// halfway through and removed from their slot once the time is over. It must
+// be called from the game state loop.
+func (g *gameManager) tickReadyCheck() {
+	if !g.gs.ReadyCheck.Active {
+		return
+	}
+	half := internal.Config().GameManager.ReadyCheckTime / 2
+	if g.gs.ReadyCheck.RemainingTime > 1000 {
+		g.gs.ReadyCheck.RemainingTime = g.gs.ReadyCheck.RemainingTime - 1000
+		if g.gs.ReadyCheck.RemainingTime+1000 > half && g.gs.ReadyCheck.RemainingTime <= half {
+			g.pingUnready("Still waiting on you to be ready in the LoI lobby")
+		}
+		return
+	}
+	slog.Info("[tickReadyCheck] - ready check timed out")
+	g.finishReadyCheck(context.Background())
+}
+
+// finishReadyCheck removes the players who did not answer from their
+// slot and rolls for the remaining ones. It must be called from the game state loop.
+func (g *gameManager) finishReadyCheck(ctx context.Context) {
+	remaining := 0
+	for i, p := range g.gs.Players {
+		if p.Player.ID == "" {
+			continue
+		}
+		if !g.gs.ReadyCheck.Ready[p.Player.ID] {
+			slog.Warn(fmt.Sprintf("[finishReadyCheck] - removing player '%s' from slot %d, player is not ready", p.Player.ID, i))
+			g.gs.Players[i] = model.NewEmptyGamePlayer()
+			continue
+		}
+		remaining++
+	}
+	g.gs.ReadyCheck = model.NewEmptyReadyCheck()
+	if remaining == 0 {
+		slog.Info("[finishReadyCheck] - no player is ready, skipping roll")
+		g.broadcastState("[finishReadyCheck]")
+		return
+	}
+	if _, err := g.roll(ctx); err != nil {
+		slog.Error(fmt.Sprintf("[finishReadyCheck] - failed to roll : %s", err.Error()))
+		g.broadcastState("[finishReadyCheck]")
+	}
+}
+
+// inSlot must be called from the game state loop.
+func (g *gameManager) inSlot(playerID string) bool {
+	for _, p := range g.gs.Players {
+		if p.Player.ID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// pingUnready mentions the players who did not answer the ready check in
+// the discord text channel. It must be called from the game state loop.
+func (g *gameManager) pingUnready(content string) {
+	mentions := make([]string, 0, len(g.gs.ReadyCheck.Ready))
+	for id, ready := range g.gs.ReadyCheck.Ready {
+		if ready {
+			continue
+		}
+		mentions = append(mentions, "<@"+id+">")
+	}
+	if len(mentions) == 0 || g.dm == nil {
+		return
+	}
+	msg := strings.Join(mentions, " ") + " " + content
+	go func() {
+		if err := g.dm.SendTextMessage(msg); err != nil {
+			slog.Error(fmt.Sprintf("[pingUnready] - failed to ping players : %s", err.Error()))
+		}
+	}()
+}
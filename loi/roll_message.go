@@ -0,0 +1,188 @@
+package loi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+)
+
+const (
+	// ddragonChampionIconURL is formatted with the league version and the
+	// champion image.
+	ddragonChampionIconURL = "https://ddragon.leagueoflegends.com/cdn/%s/img/champion/%s"
+	// maxEmbeds is the number of embeds discord accepts in a message.
+	maxEmbeds = 10
+)
+
+// Custom ids of the buttons of the roll message.
+const (
+	ButtonReroll = "loi:reroll"
+	ButtonCancel = "loi:cancel"
+	ButtonLock   = "loi:lock"
+	ButtonReady  = "loi:ready"
+)
+
+type rollMessageStatus int
+
+const (
+	rollMessageLive rollMessageStatus = iota
+	rollMessageFinished
+	rollMessageCancelled
+)
+
+// rollMessage is an update of the roll results message of a game.
+type rollMessage struct {
+	gameID     uint
+	status     rollMessageStatus
+	embeds     []*discordgo.MessageEmbed
+	components []discordgo.MessageComponent
+}
+
+var rollMessageColors = map[rollMessageStatus]int{
+	rollMessageLive:      0x5865f2,
+	rollMessageFinished:  0x57f287,
+	rollMessageCancelled: 0x99aab5,
+}
+
+// rollEmbeds lists the role and champion of every player of the game, each
+// player has its own embed to show the champion icon.
+func rollEmbeds(gs model.GameState, status rollMessageStatus) []*discordgo.MessageEmbed {
+	color := rollMessageColors[status]
+	header := &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("Loi des norms #%d", gs.GameId),
+		Color: color,
+	}
+	switch status {
+	case rollMessageLive:
+		header.Description = fmt.Sprintf("Roll #%d", gs.RollCount)
+	case rollMessageFinished:
+		header.Description = fmt.Sprintf("Finished after %d rolls", gs.RollCount)
+	case rollMessageCancelled:
+		header.Description = "Cancelled"
+	}
+	embeds := []*discordgo.MessageEmbed{header}
+	for i, p := range gs.Players {
+		if p.Player.ID == "" {
+			continue
+		}
+		// mentions are only rendered in the description
+		name := fmt.Sprintf("<@%s>", p.Player.ID)
+		if p.Player.Name != nil && *p.Player.Name != "" {
+			name = *p.Player.Name
+		}
+		if gs.Settings.Teams > 1 {
+			name = fmt.Sprintf("Team %d - %s", model.TeamOf(i)+1, name)
+		}
+		e := &discordgo.MessageEmbed{
+			Description: name,
+			Color:       color,
+		}
+		if p.Role != nil && p.Champion != nil {
+			e.Description = fmt.Sprintf("%s\n%s - **%s**", name, *p.Role, p.Champion.Name)
+			if p.Locked {
+				e.Description += " (locked)"
+			}
+			if gs.LeagueVersion != "" && p.Champion.Img != "" {
+				e.Thumbnail = &discordgo.MessageEmbedThumbnail{
+					URL: fmt.Sprintf(ddragonChampionIconURL, gs.LeagueVersion, p.Champion.Img),
+				}
+			}
+		}
+		embeds = append(embeds, e)
+	}
+	// a full two teams game has no room for the header, it moves to the
+	// first player
+	if len(embeds) > maxEmbeds {
+		embeds = embeds[1:]
+		embeds[0].Title = header.Title
+		embeds[0].Footer = &discordgo.MessageEmbedFooter{Text: header.Description}
+	}
+	return embeds
+}
+
+// rollComponents are the buttons of a live roll message, a closed message
+// has none.
+func rollComponents(status rollMessageStatus) []discordgo.MessageComponent {
+	if status != rollMessageLive {
+		return nil
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Reroll", Style: discordgo.PrimaryButton, CustomID: ButtonReroll},
+				discordgo.Button{Label: "Cancel", Style: discordgo.DangerButton, CustomID: ButtonCancel},
+				discordgo.Button{Label: "Lock my pick", Style: discordgo.SecondaryButton, CustomID: ButtonLock},
+				discordgo.Button{Label: "Ready", Style: discordgo.SuccessButton, CustomID: ButtonReady},
+			},
+		},
+	}
+}
+
+// queueRollMessage posts or updates the roll results message of the current
+// game without waiting for discord. It must be called from the game state
+// loop.
+func (g *gameManager) queueRollMessage(status rollMessageStatus) {
+	if g.dm == nil {
+		return
+	}
+	m := rollMessage{
+		gameID:     g.gs.GameId,
+		status:     status,
+		embeds:     rollEmbeds(*g.gs, status),
+		components: rollComponents(status),
+	}
+	g.rollMessages.push(m)
+}
+
+// supersedesRollMessage only collapses the live updates of a game, the
+// message is closed even when discord is behind.
+func supersedesRollMessage(m rollMessage, waiting rollMessage) bool {
+	return m.status == rollMessageLive && waiting.status == rollMessageLive && m.gameID == waiting.gameID
+}
+
+// postRollMessages posts the roll messages in the order they are queued, the
+// first message of a game is posted and the next ones edit it.
+func (g *gameManager) postRollMessages() {
+	ids := map[uint]string{}
+	g.rollMessages.run(func(m rollMessage) {
+		id, ok := ids[m.gameID]
+		if !ok {
+			// a cancelled game is already deleted when its message is closed
+			var game sharedmodel.Game
+			if err := g.d.Database(context.Background()).Unscoped().Select("discord_message_id").First(&game, m.gameID).Error; err == nil && game.DiscordMessageID != nil {
+				id = *game.DiscordMessageID
+			}
+		}
+		if m.status != rollMessageLive {
+			delete(ids, m.gameID)
+		}
+		if id != "" {
+			if err := g.dm.EditEmbeds(id, m.embeds, m.components); err != nil {
+				slog.Error(fmt.Sprintf("[postRollMessages] - failed to edit the message of game %d : %s", m.gameID, err.Error()))
+			}
+			if m.status == rollMessageLive {
+				ids[m.gameID] = id
+			}
+			return
+		}
+		if m.status != rollMessageLive {
+			return
+		}
+		id, err := g.dm.SendEmbeds(m.embeds, m.components)
+		if err != nil {
+			slog.Error(fmt.Sprintf("[postRollMessages] - failed to post the message of game %d : %s", m.gameID, err.Error()))
+			return
+		}
+		if id == "" {
+			return
+		}
+		ids[m.gameID] = id
+		if err := g.d.Database(context.Background()).Model(&sharedmodel.Game{}).Where("id = ?", m.gameID).Update("discord_message_id", id).Error; err != nil {
+			slog.Error(fmt.Sprintf("[postRollMessages] - failed to save the message of game %d : %s", m.gameID, err.Error()))
+		}
+	})
+}
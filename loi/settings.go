@@ -0,0 +1,83 @@
+package loi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+)
+
+// UpdateSettings changes the rules of the next game, the settings are fixed
+// once the first roll is made and stay the same until the game is reset.
+func (g *gameManager) UpdateSettings(ctx context.Context, actor Actor, s model.GameSettings) error {
+	if err := g.checkBannedChampions(ctx, s.BannedChampionIDs); err != nil {
+		return err
+	}
+	return g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
+		}
+		if g.gs.GameInProgress {
+			return ErrGameInProgress
+		}
+		if !s.RollStrategy.Valid() {
+			return fmt.Errorf("%w : unknown roll strategy '%s'", ErrInvalidContent, s.RollStrategy)
+		}
+		if s.BannedChampionIDs == nil {
+			s.BannedChampionIDs = []string{}
+		}
+		if s.Teams == 0 {
+			s.Teams = 1
+		}
+		if s.Teams > 2 {
+			return fmt.Errorf("%w : %d teams, at most 2 are supported", ErrInvalidContent, s.Teams)
+		}
+		if s.MoveToTeamChannels && s.Teams > 1 {
+			if err := g.checkTeamMovePermissions(s.Teams); err != nil {
+				return err
+			}
+		}
+		if err := g.checkLaneRolePermissions(s); err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("[UpdateSettings] - updating game settings to %+v", s))
+		g.gs.Settings = s
+		// the slots follow the number of teams, the players of a removed
+		// team leave their slot
+		for len(g.gs.Players) < s.SlotCount() {
+			g.gs.Players = append(g.gs.Players, model.NewEmptyGamePlayer())
+		}
+		if len(g.gs.Players) > s.SlotCount() {
+			g.gs.Players = g.gs.Players[:s.SlotCount()]
+			g.electHost()
+		}
+
+		g.broadcastState("[UpdateSettings]")
+		return nil
+	})
+}
+
+// checkBannedChampions returns ErrInvalidContent when a banned champion is
+// unknown or banned twice.
+func (g *gameManager) checkBannedChampions(ctx context.Context, ids []string) error {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("%w : champion '%s' is banned twice", ErrInvalidContent, id)
+		}
+		seen[id] = true
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var n int64
+	if err := g.d.Database(ctx).Model(&sharedmodel.Champion{}).Where("id IN ?", ids).Count(&n).Error; err != nil {
+		return err
+	}
+	if int(n) != len(ids) {
+		return fmt.Errorf("%w : unknown champion in the banned champions %v", ErrInvalidContent, ids)
+	}
+	return nil
+}
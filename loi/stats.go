@@ -0,0 +1,63 @@
+package loi
+
+import (
+	"context"
+
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+)
+
+// statsTopChampions is the number of most rolled champions in the stats.
+const statsTopChampions = 3
+
+type ChampionCount struct {
+	Champion model.Champion
+	Count    int64
+}
+
+type PlayerStats struct {
+	PlayerID     string
+	Games        int64
+	Rolls        int64
+	PoolSize     int64
+	TopChampions []ChampionCount
+}
+
+// Stats returns the games played and the champions rolled by a player.
+func (g *gameManager) Stats(ctx context.Context, playerID string) (*PlayerStats, error) {
+	db := g.d.Database(ctx)
+	s := &PlayerStats{PlayerID: playerID}
+	if err := db.Model(&sharedmodel.GamePlayer{}).Where("player_id = ?", playerID).Count(&s.Games).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&sharedmodel.GamePlayerRoll{}).Where("player_id = ?", playerID).Count(&s.Rolls).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&sharedmodel.PlayerChampion{}).Where("player_id = ?", playerID).Count(&s.PoolSize).Error; err != nil {
+		return nil, err
+	}
+	var top []struct {
+		ChampionID string
+		Count      int64
+	}
+	if err := db.Model(&sharedmodel.GamePlayerRoll{}).
+		Select("champion_id, COUNT(*) AS count").
+		Where("player_id = ? AND champion_id IS NOT NULL", playerID).
+		Group("champion_id").
+		Order("count DESC, champion_id").
+		Limit(statsTopChampions).
+		Scan(&top).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range top {
+		var c sharedmodel.Champion
+		if err := db.First(&c, "id = ?", t.ChampionID).Error; err != nil {
+			return nil, err
+		}
+		s.TopChampions = append(s.TopChampions, ChampionCount{
+			Champion: *model.ChampionFromDB(&c),
+			Count:    t.Count,
+		})
+	}
+	return s, nil
+}
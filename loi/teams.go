@@ -0,0 +1,204 @@
+package loi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/phturb/bonjack-tools-backend-go/discord"
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+)
+
+// teamMovePermissions are needed by the bot in the lobby channel and in the
+// team channels to move the players.
+const teamMovePermissions = discordgo.PermissionVoiceMoveMembers | discordgo.PermissionVoiceConnect
+
+// teamMove moves the players of each team in the voice channel of their
+// team, or every player moved in a team channel back in the lobby channel.
+type teamMove struct {
+	guildID   string
+	channelID string
+	teams     [][]string
+	back      bool
+}
+
+// teams returns the player ids of each team to move, the guests are not in
+// discord. It must be called from the game state loop.
+func (g *gameManager) teams() [][]string {
+	teams := make([][]string, (len(g.gs.Players)+model.TeamSize-1)/model.TeamSize)
+	for i, p := range g.gs.Players {
+		if p.Player.ID == "" || p.Player.Guest {
+			continue
+		}
+		t := model.TeamOf(i)
+		teams[t] = append(teams[t], p.Player.ID)
+	}
+	return teams
+}
+
+// moveToTeamChannels tells if the players are moved in the team channels once
+// the game starts. It must be called from the game state loop.
+func (g *gameManager) moveToTeamChannels() bool {
+	return g.dm != nil && g.gs.Settings.MoveToTeamChannels && g.gs.Settings.Teams > 1
+}
+
+// checkTeamMovePermissions makes sure the bot can move the players before the
+// settings are saved. It must be called from the game state loop.
+func (g *gameManager) checkTeamMovePermissions(teams uint) error {
+	if g.dm == nil {
+		return fmt.Errorf("%w : no discord bot is configured", ErrDiscordUnavailable)
+	}
+	checks := map[string]int64{g.channelID: teamMovePermissions}
+	ids := internal.Config().Discord.TeamChannelIDs
+	if len(ids) >= int(teams) {
+		for _, id := range ids[:teams] {
+			checks[id] = teamMovePermissions
+		}
+	} else {
+		checks[g.channelID] |= discordgo.PermissionManageChannels
+	}
+	for id, perms := range checks {
+		if err := g.dm.CheckPermissions(id, perms); err != nil {
+			return permissionError(err)
+		}
+	}
+	return nil
+}
+
+func permissionError(err error) error {
+	if errors.Is(err, discord.ErrMissingPermission) {
+		return fmt.Errorf("%w : %s", ErrMissingPermission, err.Error())
+	}
+	return fmt.Errorf("%w : %s", ErrDiscordUnavailable, err.Error())
+}
+
+// queueTeamMove moves the players without waiting for discord. It must be
+// called from the game state loop.
+func (g *gameManager) queueTeamMove(back bool) {
+	m := teamMove{
+		guildID:   g.gs.DiscordGuildID,
+		channelID: g.channelID,
+		back:      back,
+	}
+	if !back {
+		m.teams = g.teams()
+	}
+	g.teamMoves.push(m)
+}
+
+// moveTeams applies the team moves in the order they are queued.
+func (g *gameManager) moveTeams() {
+	g.teamMoves.run(func(m teamMove) {
+		if m.back {
+			g.moveBack(m)
+		} else {
+			g.moveIn(m)
+		}
+	})
+}
+
+// teamChannelIDs returns the configured team channels, or the ones created
+// next to the lobby channel.
+func (g *gameManager) teamChannelIDs(guildID string, channelID string, teams int) ([]string, error) {
+	ids := internal.Config().Discord.TeamChannelIDs
+	if len(ids) >= teams {
+		return ids[:teams], nil
+	}
+	ids = make([]string, 0, teams)
+	for t := 1; t <= teams; t++ {
+		c, err := g.dm.EnsureVoiceChannel(guildID, channelID, fmt.Sprintf("Team %d", t))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+func (g *gameManager) moveIn(m teamMove) {
+	ids, err := g.teamChannelIDs(m.guildID, m.channelID, len(m.teams))
+	if err == nil {
+		for _, id := range append([]string{m.channelID}, ids...) {
+			if err = g.dm.CheckPermissions(id, teamMovePermissions); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		g.reportMoveError(permissionError(err))
+		return
+	}
+	// the team channels are part of the lobby before anyone is moved so the
+	// players stay available
+	g.do(context.Background(), func() error {
+		g.teamChannels = map[string]bool{}
+		for _, id := range ids {
+			g.teamChannels[id] = true
+		}
+		g.updateLobby()
+		return nil
+	})
+	db := g.d.Database(context.Background())
+	for t, players := range m.teams {
+		for _, id := range players {
+			if g.dm.VoiceChannelID(m.guildID, id) != m.channelID {
+				slog.Info(fmt.Sprintf("[moveIn] - player '%s' is not in the lobby channel, not moving it", id))
+				continue
+			}
+			slog.Info(fmt.Sprintf("[moveIn] - moving player '%s' in the channel of team %d", id, t+1))
+			if err := g.dm.MoveMember(m.guildID, id, ids[t]); err != nil {
+				slog.Warn(fmt.Sprintf("[moveIn] - failed to move player '%s' : %s", id, err.Error()))
+				continue
+			}
+			if err := db.Save(&sharedmodel.TeamMove{GuildID: m.guildID, UserID: id, ChannelID: ids[t]}).Error; err != nil {
+				slog.Error(fmt.Sprintf("[moveIn] - failed to save the move of player '%s' : %s", id, err.Error()))
+			}
+		}
+	}
+}
+
+// moveBack moves back every player moved in a team channel that is still in
+// one, the moves that failed are kept to be retried on startup.
+func (g *gameManager) moveBack(m teamMove) {
+	var channels map[string]bool
+	g.do(context.Background(), func() error {
+		channels = g.teamChannels
+		return nil
+	})
+	db := g.d.Database(context.Background())
+	var moves []sharedmodel.TeamMove
+	if err := db.Find(&moves, "guild_id = ?", m.guildID).Error; err != nil {
+		slog.Error(fmt.Sprintf("[moveBack] - failed to load the team moves : %s", err.Error()))
+	}
+	for _, tm := range moves {
+		current := g.dm.VoiceChannelID(m.guildID, tm.UserID)
+		if current == tm.ChannelID || channels[current] {
+			slog.Info(fmt.Sprintf("[moveBack] - moving player '%s' back in the lobby channel", tm.UserID))
+			if err := g.dm.MoveMember(m.guildID, tm.UserID, m.channelID); err != nil && !isUnknown(err) {
+				slog.Warn(fmt.Sprintf("[moveBack] - failed to move player '%s', keeping the move to retry on startup : %s", tm.UserID, err.Error()))
+				continue
+			}
+		}
+		if err := db.Delete(&tm).Error; err != nil {
+			slog.Error(fmt.Sprintf("[moveBack] - failed to delete the move of player '%s' : %s", tm.UserID, err.Error()))
+		}
+	}
+	g.do(context.Background(), func() error {
+		g.teamChannels = nil
+		g.updateLobby()
+		return nil
+	})
+}
+
+// reportMoveError tells the players why they were not moved, the moves are
+// made after the roll so the error can't be sent back to the command.
+func (g *gameManager) reportMoveError(err error) {
+	slog.Error(fmt.Sprintf("[reportMoveError] - unable to move the players : %s", err.Error()))
+	if err := g.dm.SendTextMessage(fmt.Sprintf("Unable to move the players in the team channels : %s", err.Error())); err != nil {
+		slog.Error(fmt.Sprintf("[reportMoveError] - failed to send the error : %s", err.Error()))
+	}
+}
@@ -0,0 +1,68 @@
+package loi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+)
+
+// PauseTimer freezes the roll cooldown while the players are in champion
+// select or loading, no roll is allowed until the timer is resumed.
+func (g *gameManager) PauseTimer(ctx context.Context, actor Actor) error {
+	return g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
+		}
+		if !g.gs.GameInProgress {
+			return ErrNoGameInProgress
+		}
+		if g.gs.TimerPaused {
+			return ErrTimerPaused
+		}
+		if err := g.d.Database(ctx).Create(&sharedmodel.GamePause{
+			GameID:   g.gs.GameId,
+			PausedAt: time.Now(),
+		}).Error; err != nil {
+			slog.Error(fmt.Sprintf("[PauseTimer] - failed to log the pause : %s", err.Error()))
+			return err
+		}
+		slog.Info(fmt.Sprintf("[PauseTimer] - pausing the timer with %dms remaining", g.gs.NextRollTimer))
+		g.gs.TimerPaused = true
+		g.gs.CanRoll = false
+
+		g.broadcastState("[PauseTimer]")
+		return nil
+	})
+}
+
+// ResumeTimer implements GameManager.
+func (g *gameManager) ResumeTimer(ctx context.Context, actor Actor) error {
+	return g.do(ctx, func() error {
+		if !g.canControl(actor.PlayerID) {
+			return ErrForbidden
+		}
+		if !g.gs.TimerPaused {
+			return ErrTimerNotPaused
+		}
+		g.endPause(ctx)
+		slog.Info(fmt.Sprintf("[ResumeTimer] - resuming the timer with %dms remaining", g.gs.NextRollTimer))
+		g.gs.TimerPaused = false
+		g.gs.CanRoll = g.gs.NextRollTimer == 0 && !g.rerollsExhausted()
+
+		g.broadcastState("[ResumeTimer]")
+		return nil
+	})
+}
+
+// endPause records the end of the current pause of the game. It must be
+// called from the game state loop.
+func (g *gameManager) endPause(ctx context.Context) {
+	if err := g.d.Database(ctx).Model(&sharedmodel.GamePause{}).
+		Where("game_id = ? AND resumed_at IS NULL", g.gs.GameId).
+		Update("resumed_at", time.Now()).Error; err != nil {
+		slog.Error(fmt.Sprintf("[endPause] - failed to log the end of the pause : %s", err.Error()))
+	}
+}
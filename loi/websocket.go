@@ -0,0 +1,486 @@
+package loi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/auth"
+	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+)
+
+// commandTimeout bounds the time a websocket command can spend on the
+// database, the upgrade request context lives as long as the connection.
+const commandTimeout = 10 * time.Second
+
+// actorFromRequest returns the actor behind the websocket upgrade request, the
+// player is the discord user of the signed session and never an id sent by
+// the client, a request without session is a spectator.
+func actorFromRequest(r *http.Request) Actor {
+	a := Actor{Source: "websocket"}
+	if r == nil {
+		return a
+	}
+	if s, ok := auth.SessionFromContext(r.Context()); ok {
+		a.PlayerID = s.PlayerID
+	}
+	return a
+}
+
+// HandleWebsocketConnection implements GameManager.
+func (g *gameManager) HandleWebsocketConnection(conn *websocket.Conn, r *http.Request, hello *modelwebsocket.Message) error {
+	slog.Info("[HandleWebsocketConnection] - handling websocket connection")
+	// the connection is not registered yet, the rejections are written
+	// directly since no other goroutine writes to it.
+	reject := func(code string, msg string) error {
+		if m, err := websocketError(hello, code, msg); err == nil {
+			conn.WriteJSON(m)
+		}
+		return errors.New(msg)
+	}
+	if hello == nil || hello.Action != modelwebsocket.Hello {
+		return reject(modelwebsocket.CodeHelloRequired, "the first message must be a hello")
+	}
+	var p modelwebsocket.HelloPayload
+	if err := hello.DecodePayload(&p); err != nil {
+		return reject(modelwebsocket.CodeInvalidMessage, err.Error())
+	}
+	if p.ProtocolVersion < modelwebsocket.MinProtocolVersion || p.ProtocolVersion > modelwebsocket.ProtocolVersion {
+		return reject(modelwebsocket.CodeUnsupportedVersion, fmt.Sprintf("protocol version %d is not supported, the server supports versions %d to %d", p.ProtocolVersion, modelwebsocket.MinProtocolVersion, modelwebsocket.ProtocolVersion))
+	}
+	patches := false
+	features := []string{}
+	for _, f := range p.Features {
+		if f == modelwebsocket.FeaturePatchState && !patches {
+			patches = true
+			features = append(features, f)
+		}
+	}
+	actor := actorFromRequest(r)
+	c := newClient(conn, actor.PlayerID, patches, newLimiter(g.rateLimit, g.rateBurst))
+	// registering the connection from the game state loop makes sure no
+	// broadcast happens between the initial state and the registration.
+	return g.do(context.Background(), func() error {
+		m, err := modelwebsocket.NewMessage(hello.ID, modelwebsocket.Welcome, modelwebsocket.WelcomePayload{
+			ProtocolVersion: modelwebsocket.ProtocolVersion,
+			Features:        features,
+			ClientActions:   modelwebsocket.ClientActions,
+			ServerActions:   modelwebsocket.ServerActions,
+			Lobby: modelwebsocket.LobbyInfo{
+				GuildID:     g.gs.DiscordGuildID,
+				GuildName:   g.gs.DiscordGuildName,
+				ChannelID:   g.gs.DiscordGuildChannelID,
+				ChannelName: g.gs.DiscordGuildChannelName,
+				PlayerID:    actor.PlayerID,
+				Role:        g.lobbyRole(actor.PlayerID),
+			},
+		})
+		if err != nil {
+			slog.Error(fmt.Sprintf("[HandleWebsocketConnection] - failed to marshal welcome : %s", err.Error()))
+			return err
+		}
+		g.register(c)
+		c.enqueue(m)
+		slog.Info("[HandleWebsocketConnection] - sending game state to the new connection")
+		g.sendSnapshot(conn)
+		return nil
+	})
+}
+
+// broadcast queues for every connection the message returned for it.
+func (g *gameManager) broadcast(m func(c *client) modelwebsocket.Message) {
+	slog.Info("[broadcast] - broadcasting message")
+	g.connsMu.RLock()
+	defer g.connsMu.RUnlock()
+	for _, c := range g.conns {
+		c.enqueue(m(c))
+	}
+	for c := range g.streams {
+		c.enqueue(m(c))
+	}
+}
+
+// broadcastState sends the changes since the last sent state to every
+// connection as a patch with the next sequence number. It must be called from
+// the game state loop.
+func (g *gameManager) broadcastState(prefix string) {
+	from, err := toDocument(g.sent)
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s - failed to marshal sent game state : %s", prefix, err.Error()))
+		return
+	}
+	to, err := toDocument(g.gs)
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s - failed to marshal game state : %s", prefix, err.Error()))
+		return
+	}
+	ops, err := diffDocuments("", from, to)
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s - failed to diff game state : %s", prefix, err.Error()))
+		return
+	}
+	if len(ops) == 0 {
+		return
+	}
+	patch, err := modelwebsocket.NewMessage("", modelwebsocket.PatchState, modelwebsocket.PatchStatePayload{
+		Seq:        g.seq + 1,
+		Operations: ops,
+	})
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s - failed to marshal state patch : %s", prefix, err.Error()))
+		return
+	}
+	g.seq++
+	g.sent = g.gs.Clone()
+	snapshot, err := g.snapshot()
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s - failed to marshal game state : %s", prefix, err.Error()))
+		return
+	}
+	g.broadcast(func(c *client) modelwebsocket.Message {
+		if c.patches {
+			return patch
+		}
+		return snapshot
+	})
+}
+
+// snapshot returns the last sent state as an updateState message. It must be
+// called from the game state loop.
+func (g *gameManager) snapshot() (modelwebsocket.Message, error) {
+	return modelwebsocket.NewMessage("", modelwebsocket.UpdateState, modelwebsocket.UpdateStatePayload{
+		Seq:   g.seq,
+		State: g.sent,
+	})
+}
+
+// sendSnapshot sends the full game state to a single connection, pending
+// changes are broadcasted first so the snapshot matches its sequence number.
+// It must be called from the game state loop.
+func (g *gameManager) sendSnapshot(conn *websocket.Conn) {
+	g.broadcastState("[sendSnapshot]")
+	m, err := g.snapshot()
+	if err != nil {
+		slog.Error(fmt.Sprintf("[sendSnapshot] - failed to marshal game state : %s", err.Error()))
+		return
+	}
+	g.send(conn, m)
+}
+
+// send queues a message for a single connection, messages for a connection
+// that is not registered or already removed are dropped.
+func (g *gameManager) send(conn *websocket.Conn, m modelwebsocket.Message) {
+	if conn == nil {
+		return
+	}
+	g.connsMu.RLock()
+	c, ok := g.conns[conn]
+	g.connsMu.RUnlock()
+	if !ok {
+		slog.Debug(fmt.Sprintf("[send] - dropping %s message for an unregistered connection", m.Action))
+		return
+	}
+	c.enqueue(m)
+}
+
+func websocketError(wm *modelwebsocket.Message, code string, message string) (modelwebsocket.Message, error) {
+	var id string
+	var action modelwebsocket.Action
+	if wm != nil {
+		id = wm.ID
+		action = wm.Action
+	}
+	return modelwebsocket.NewMessage(id, modelwebsocket.Error, modelwebsocket.ErrorPayload{
+		Action:  action,
+		Code:    code,
+		Message: message,
+	})
+}
+
+// SendWebsocketError implements GameManager.
+func (g *gameManager) SendWebsocketError(conn *websocket.Conn, wm *modelwebsocket.Message, code string, message string) {
+	m, err := websocketError(wm, code, message)
+	if err != nil {
+		slog.Error(fmt.Sprintf("[SendWebsocketError] - failed to marshal error : %s", err.Error()))
+		return
+	}
+	g.send(conn, m)
+}
+
+// reply acknowledges the message or reports the reason the command failed to
+// the connection that sent it.
+func (g *gameManager) reply(conn *websocket.Conn, wm *modelwebsocket.Message, cmdErr error) {
+	if cmdErr != nil {
+		code := string(CodeInternal)
+		message := cmdErr.Error()
+		var ce *CommandError
+		var ae *admissionError
+		switch {
+		case errors.As(cmdErr, &ce):
+			code = string(ce.Code)
+		case errors.As(cmdErr, &ae):
+			code = ae.code
+			message = ae.message
+		}
+		g.SendWebsocketError(conn, wm, code, message)
+		return
+	}
+	m, err := modelwebsocket.NewMessage(wm.ID, modelwebsocket.Ack, modelwebsocket.AckPayload{Action: wm.Action})
+	if err != nil {
+		slog.Error(fmt.Sprintf("[reply] - failed to marshal ack : %s", err.Error()))
+		return
+	}
+	g.send(conn, m)
+}
+
+// HandleWebsocketMessage implements GameManager.
+func (g *gameManager) HandleWebsocketMessage(wm *modelwebsocket.Message, conn *websocket.Conn, r *http.Request) bool {
+	slog.Info(fmt.Sprintf("[HandleWebsocketMessage] - %s event received", wm.Action))
+	g.connsMu.RLock()
+	c := g.conns[conn]
+	g.connsMu.RUnlock()
+	if c != nil {
+		c.touch()
+	}
+	actor := actorFromRequest(r)
+	if err := g.admit(c, actor.PlayerID); err != nil {
+		slog.Warn(fmt.Sprintf("[HandleWebsocketMessage] - refusing %s from player '%s' : %s", wm.Action, actor.PlayerID, err.Error()))
+		g.reply(conn, wm, err)
+		return true
+	}
+	var h func(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error
+	switch wm.Action {
+	case modelwebsocket.UpdatePlayers:
+		h = g.handleUpdatePlayers
+	case modelwebsocket.Roll:
+		h = g.handleRoll
+	case modelwebsocket.Cancel:
+		h = g.handleCancel
+	case modelwebsocket.Reset:
+		h = g.handleReset
+	case modelwebsocket.RefreshDiscord:
+		h = g.handleRefreshDiscord
+	case modelwebsocket.TransferHost:
+		h = g.handleTransferHost
+	case modelwebsocket.UpdateSettings:
+		h = g.handleUpdateSettings
+	case modelwebsocket.StartReadyCheck:
+		h = g.handleStartReadyCheck
+	case modelwebsocket.Ready:
+		h = g.handleReady
+	case modelwebsocket.PauseTimer:
+		h = g.handlePauseTimer
+	case modelwebsocket.ResumeTimer:
+		h = g.handleResumeTimer
+	case modelwebsocket.RequestSnapshot:
+		h = g.handleRequestSnapshot
+	case modelwebsocket.ListVoiceChannels:
+		h = g.handleListVoiceChannels
+	case modelwebsocket.SwitchVoiceChannel:
+		h = g.handleSwitchVoiceChannel
+	case modelwebsocket.AddRosterMember:
+		h = g.handleAddRosterMember
+	case modelwebsocket.RemoveRosterMember:
+		h = g.handleRemoveRosterMember
+	case modelwebsocket.AddGuest:
+		h = g.handleAddGuest
+	case modelwebsocket.RemoveGuest:
+		h = g.handleRemoveGuest
+	case modelwebsocket.MergeGuest:
+		h = g.handleMergeGuest
+	case modelwebsocket.AddToGuestPool:
+		h = g.handleAddToGuestPool
+	case modelwebsocket.RemoveFromGuestPool:
+		h = g.handleRemoveFromGuestPool
+	default:
+		slog.Debug(fmt.Sprintf("websocket action '%s' is not handled by the game manager", wm.Action))
+		return false
+	}
+	select {
+	case g.handlers <- struct{}{}:
+	default:
+		slog.Warn(fmt.Sprintf("[HandleWebsocketMessage] - too many commands running, refusing %s", wm.Action))
+		g.SendWebsocketError(conn, wm, modelwebsocket.CodeBusy, "too many commands running, try again")
+		return true
+	}
+	go func() {
+		defer func() { <-g.handlers }()
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		defer cancel()
+		err := h(ctx, actor, wm, conn)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("[HandleWebsocketMessage] - %s failed for player '%s' : %s", wm.Action, actor.PlayerID, err.Error()))
+		}
+		g.reply(conn, wm, err)
+	}()
+	return true
+}
+
+// decodePayload unmarshals the message payload, a malformed payload is
+// reported as invalid content.
+func decodePayload(wm *modelwebsocket.Message, v interface{}) error {
+	if err := wm.DecodePayload(v); err != nil {
+		return fmt.Errorf("%w : %s", ErrInvalidContent, err.Error())
+	}
+	return nil
+}
+
+func (g *gameManager) handleUpdatePlayers(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.UpdatePlayersPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	err := g.UpdatePlayers(ctx, actor, p.Players)
+	if errors.Is(err, ErrGameInProgress) && conn != nil {
+		slog.Warn("[handleUpdatePlayers] - game is in progress, sending back the current state")
+		g.do(ctx, func() error {
+			g.sendSnapshot(conn)
+			return nil
+		})
+	}
+	return err
+}
+
+func (g *gameManager) handleRoll(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.RollPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	_, err := g.Roll(ctx, actor, RollOptions{RerollOnly: p.RerollOnly})
+	return err
+}
+
+func (g *gameManager) handleCancel(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.Cancel(ctx, actor)
+}
+
+func (g *gameManager) handleReset(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.Reset(ctx, actor)
+}
+
+func (g *gameManager) handleRefreshDiscord(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.RefreshDiscord(ctx, actor)
+}
+
+func (g *gameManager) handleTransferHost(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.TransferHostPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	return g.TransferHost(ctx, actor, p.PlayerID)
+}
+
+func (g *gameManager) handleUpdateSettings(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.UpdateSettingsPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	return g.UpdateSettings(ctx, actor, p.Settings)
+}
+
+func (g *gameManager) handleStartReadyCheck(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.StartReadyCheck(ctx, actor)
+}
+
+func (g *gameManager) handleReady(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.Ready(ctx, actor)
+}
+
+func (g *gameManager) handlePauseTimer(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.PauseTimer(ctx, actor)
+}
+
+func (g *gameManager) handleResumeTimer(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.ResumeTimer(ctx, actor)
+}
+
+func (g *gameManager) handleRequestSnapshot(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	return g.do(ctx, func() error {
+		g.sendSnapshot(conn)
+		return nil
+	})
+}
+
+func (g *gameManager) handleListVoiceChannels(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	vcs, err := g.VoiceChannels(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := modelwebsocket.NewMessage(wm.ID, modelwebsocket.VoiceChannels, modelwebsocket.VoiceChannelsPayload{Channels: vcs})
+	if err != nil {
+		return err
+	}
+	g.send(conn, m)
+	return nil
+}
+
+func (g *gameManager) handleSwitchVoiceChannel(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.SwitchVoiceChannelPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	return g.SwitchVoiceChannel(ctx, actor, p.ChannelID)
+}
+
+func (g *gameManager) handleAddRosterMember(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.AddRosterMemberPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	return g.AddRosterMember(ctx, actor, p.ID, p.Name)
+}
+
+func (g *gameManager) handleRemoveRosterMember(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.RemoveRosterMemberPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	return g.RemoveRosterMember(ctx, actor, p.ID)
+}
+
+func (g *gameManager) handleAddGuest(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.AddGuestPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	_, err := g.AddGuest(ctx, actor, p.Name)
+	return err
+}
+
+func (g *gameManager) handleRemoveGuest(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.RemoveGuestPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	return g.RemoveGuest(ctx, actor, p.ID)
+}
+
+func (g *gameManager) handleMergeGuest(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.MergeGuestPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	return g.MergeGuest(ctx, actor, p.GuestID, p.PlayerID)
+}
+
+func (g *gameManager) handleAddToGuestPool(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.GuestPoolPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	_, err := g.AddToGuestPool(ctx, actor, p.GuestID, p.Champion)
+	return err
+}
+
+func (g *gameManager) handleRemoveFromGuestPool(ctx context.Context, actor Actor, wm *modelwebsocket.Message, conn *websocket.Conn) error {
+	var p modelwebsocket.GuestPoolPayload
+	if err := decodePayload(wm, &p); err != nil {
+		return err
+	}
+	_, err := g.RemoveFromGuestPool(ctx, actor, p.GuestID, p.Champion)
+	return err
+}
@@ -12,11 +12,13 @@ import (
 	"os/signal"
 	"strconv"
 
+	"github.com/phturb/bonjack-tools-backend-go/bot"
 	"github.com/phturb/bonjack-tools-backend-go/discord"
 	"github.com/phturb/bonjack-tools-backend-go/internal"
 	"github.com/phturb/bonjack-tools-backend-go/loi"
 	"github.com/phturb/bonjack-tools-backend-go/model"
 	modellolapi "github.com/phturb/bonjack-tools-backend-go/model/lolapi"
+	"github.com/phturb/bonjack-tools-backend-go/roster"
 	"github.com/phturb/bonjack-tools-backend-go/server"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -190,12 +192,24 @@ func main() {
 	if err != nil {
 		die(err)
 	}
-	dm, err := discord.NewDiscordManager()
-	if err != nil {
-		die(err)
+	var dm discord.DiscordManager
+	var rp roster.Provider
+	if internal.Config().Discord.Token == "" {
+		slog.Warn("[core] - no discord token configured, the players are added by hand")
+		rp = roster.NewManual()
+	} else {
+		d, err := discord.NewDiscordManager()
+		if err != nil {
+			die(err)
+		}
+		dm = d
+		rp = discord.NewRoster(d.Session())
 	}
 
-	gm := loi.NewGameManager(deps, dm)
+	gm := loi.NewGameManager(deps, dm, rp)
+	if dm != nil {
+		bot.NewBot(gm, dm)
+	}
 	s, err := server.NewServer(gm)
 	if err != nil {
 		die(err)
@@ -203,11 +217,13 @@ func main() {
 
 	sErr := make(chan error)
 	startServer := func(ctx context.Context) {
-		if err := dm.Session().Open(); err != nil {
-			sErr <- err
+		if dm != nil {
+			if err := dm.Session().Open(); err != nil {
+				sErr <- err
+			}
+			defer dm.Session().Close()
 		}
 		sch := s.Start(ctx)
-		defer dm.Session().Close()
 		select {
 		case <-ctx.Done():
 			sErr <- nil
@@ -1,11 +1,40 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Game struct {
 	gorm.Model
-	Players []GamePlayer     `gorm:"foreignKey:GameID"`
-	Rolls   []GamePlayerRoll `gorm:"foreignKey:GameID"`
+	Settings GameSettings     `gorm:"embedded;embeddedPrefix:settings_"`
+	Players  []GamePlayer     `gorm:"foreignKey:GameID"`
+	Rolls    []GamePlayerRoll `gorm:"foreignKey:GameID"`
+	Pauses   []GamePause      `gorm:"foreignKey:GameID"`
+	// DiscordMessageID is the roll results message posted in the text
+	// channel.
+	DiscordMessageID *string
+}
+
+type GameSettings struct {
+	Cooldown              uint
+	MaxRerolls            uint
+	RollStrategy          string
+	IncludeWeeklyRotation bool
+	BannedChampionIDs     []string `gorm:"serializer:json"`
+	Teams                 uint
+	MoveToTeamChannels    bool
+	LaneRoles             bool
+	ChampionNicknames     bool
+}
+
+type GamePause struct {
+	ID        uint `gorm:"primaryKey"`
+	GameID    uint
+	PausedAt  time.Time
+	ResumedAt *time.Time
+	Game      *Game `gorm:"foreignKey:ID;references:GameID"`
 }
 
 type GamePlayer struct {
@@ -27,9 +56,18 @@ type GamePlayerRoll struct {
 	Game       *Game     `gorm:"foreignKey:ID;references:GameID"`
 }
 
+// Kinds of player, a guest has no discord account and is added from the web
+// client.
+const (
+	PlayerKindDiscord = "discord"
+	PlayerKindGuest   = "guest"
+)
+
 type Player struct {
 	ID             string `gorm:"primaryKey"`
 	Name           *string
+	Avatar         *string
+	Kind           string           `gorm:"default:discord"`
 	GamePlayer     []GamePlayer     `gorm:"foreignKey:PlayerID"`
 	GamePlayerRoll []GamePlayerRoll `gorm:"foreignKey:PlayerID"`
 	PlayerChampion []PlayerChampion `gorm:"foreignKey:PlayerID"`
@@ -61,6 +99,34 @@ type LaneRole struct {
 	GamePlayerRoll []GamePlayerRoll `gorm:"foreignKey:Role"`
 }
 
+// LobbyChannel is the voice channel tracked by the lobby of a guild, it
+// replaces the configured channel once chosen.
+type LobbyChannel struct {
+	GuildID   string `gorm:"primaryKey"`
+	ChannelID string
+}
+
+// LaneAssignment is the lane role and the nickname given to a member for a
+// game, it is kept until they are removed so a failed cleanup is retried on
+// startup.
+type LaneAssignment struct {
+	GuildID string `gorm:"primaryKey"`
+	UserID  string `gorm:"primaryKey"`
+	RoleID  string
+	// Nickname is the nickname of the member before the game, it is only
+	// restored when NicknameChanged.
+	Nickname        string
+	NicknameChanged bool
+}
+
+// TeamMove is a member moved in the voice channel of its team, it is kept
+// until the member is moved back so a failed move back is retried on startup.
+type TeamMove struct {
+	GuildID   string `gorm:"primaryKey"`
+	UserID    string `gorm:"primaryKey"`
+	ChannelID string
+}
+
 type LeagueVersion struct {
 	Version string `gorm:"primaryKey"`
 }
@@ -1,15 +1,35 @@
 package modelwebsocket
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+)
 
 type Action string
 
 const (
-	UpdatePlayers  Action = "updatePlayers"
-	Roll           Action = "roll"
-	Cancel         Action = "cancel"
-	Reset          Action = "reset"
-	RefreshDiscord Action = "refreshDiscord"
+	UpdatePlayers       Action = "updatePlayers"
+	Roll                Action = "roll"
+	Cancel              Action = "cancel"
+	Reset               Action = "reset"
+	RefreshDiscord      Action = "refreshDiscord"
+	TransferHost        Action = "transferHost"
+	UpdateSettings      Action = "updateSettings"
+	StartReadyCheck     Action = "startReadyCheck"
+	Ready               Action = "ready"
+	PauseTimer          Action = "pauseTimer"
+	ResumeTimer         Action = "resumeTimer"
+	RequestSnapshot     Action = "requestSnapshot"
+	Hello               Action = "hello"
+	ListVoiceChannels   Action = "listVoiceChannels"
+	SwitchVoiceChannel  Action = "switchVoiceChannel"
+	AddRosterMember     Action = "addRosterMember"
+	RemoveRosterMember  Action = "removeRosterMember"
+	AddGuest            Action = "addGuest"
+	RemoveGuest         Action = "removeGuest"
+	MergeGuest          Action = "mergeGuest"
+	AddToGuestPool      Action = "addToGuestPool"
+	RemoveFromGuestPool Action = "removeFromGuestPool"
 )
 
 var ClientActions = []Action{
@@ -18,14 +38,41 @@ var ClientActions = []Action{
 	Cancel,
 	Reset,
 	RefreshDiscord,
+	TransferHost,
+	UpdateSettings,
+	StartReadyCheck,
+	Ready,
+	PauseTimer,
+	ResumeTimer,
+	RequestSnapshot,
+	Hello,
+	ListVoiceChannels,
+	SwitchVoiceChannel,
+	AddRosterMember,
+	RemoveRosterMember,
+	AddGuest,
+	RemoveGuest,
+	MergeGuest,
+	AddToGuestPool,
+	RemoveFromGuestPool,
 }
 
 const (
-	UpdateState Action = "updateState"
+	UpdateState   Action = "updateState"
+	Ack           Action = "ack"
+	Error         Action = "error"
+	PatchState    Action = "patchState"
+	Welcome       Action = "welcome"
+	VoiceChannels Action = "voiceChannels"
 )
 
 var ServerActions = []Action{
 	UpdateState,
+	Ack,
+	Error,
+	PatchState,
+	Welcome,
+	VoiceChannels,
 }
 
 func ActionFromString(a string) (Action, error) {
@@ -40,8 +87,52 @@ func ActionFromString(a string) (Action, error) {
 		return Reset, nil
 	case string(RefreshDiscord):
 		return RefreshDiscord, nil
+	case string(TransferHost):
+		return TransferHost, nil
+	case string(UpdateSettings):
+		return UpdateSettings, nil
+	case string(StartReadyCheck):
+		return StartReadyCheck, nil
+	case string(Ready):
+		return Ready, nil
+	case string(PauseTimer):
+		return PauseTimer, nil
+	case string(ResumeTimer):
+		return ResumeTimer, nil
+	case string(RequestSnapshot):
+		return RequestSnapshot, nil
+	case string(Hello):
+		return Hello, nil
+	case string(ListVoiceChannels):
+		return ListVoiceChannels, nil
+	case string(SwitchVoiceChannel):
+		return SwitchVoiceChannel, nil
+	case string(AddRosterMember):
+		return AddRosterMember, nil
+	case string(RemoveRosterMember):
+		return RemoveRosterMember, nil
+	case string(AddGuest):
+		return AddGuest, nil
+	case string(RemoveGuest):
+		return RemoveGuest, nil
+	case string(MergeGuest):
+		return MergeGuest, nil
+	case string(AddToGuestPool):
+		return AddToGuestPool, nil
+	case string(RemoveFromGuestPool):
+		return RemoveFromGuestPool, nil
 	case string(UpdateState):
 		return UpdateState, nil
+	case string(Ack):
+		return Ack, nil
+	case string(Error):
+		return Error, nil
+	case string(PatchState):
+		return PatchState, nil
+	case string(Welcome):
+		return Welcome, nil
+	case string(VoiceChannels):
+		return VoiceChannels, nil
 	}
 	return "", errors.New("unsuported action name")
 }
@@ -58,13 +149,86 @@ func (s Action) String() string {
 		return string(Reset)
 	case RefreshDiscord:
 		return string(RefreshDiscord)
+	case TransferHost:
+		return string(TransferHost)
+	case UpdateSettings:
+		return string(UpdateSettings)
+	case StartReadyCheck:
+		return string(StartReadyCheck)
+	case Ready:
+		return string(Ready)
+	case PauseTimer:
+		return string(PauseTimer)
+	case ResumeTimer:
+		return string(ResumeTimer)
+	case RequestSnapshot:
+		return string(RequestSnapshot)
+	case Hello:
+		return string(Hello)
+	case ListVoiceChannels:
+		return string(ListVoiceChannels)
+	case SwitchVoiceChannel:
+		return string(SwitchVoiceChannel)
+	case AddRosterMember:
+		return string(AddRosterMember)
+	case RemoveRosterMember:
+		return string(RemoveRosterMember)
+	case AddGuest:
+		return string(AddGuest)
+	case RemoveGuest:
+		return string(RemoveGuest)
+	case MergeGuest:
+		return string(MergeGuest)
+	case AddToGuestPool:
+		return string(AddToGuestPool)
+	case RemoveFromGuestPool:
+		return string(RemoveFromGuestPool)
 	case UpdateState:
 		return string(UpdateState)
+	case Ack:
+		return string(Ack)
+	case Error:
+		return string(Error)
+	case PatchState:
+		return string(PatchState)
+	case Welcome:
+		return string(Welcome)
+	case VoiceChannels:
+		return string(VoiceChannels)
 	}
 	return "unknown"
 }
 
+// Message is the envelope of every websocket message. The id is set by the
+// client on its requests and sent back in the matching ack or error, the
+// payload type depends on the action.
 type Message struct {
-	Action  Action `json:"action"`
-	Content string `json:"content,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Action  Action          `json:"action"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func NewMessage(id string, action Action, payload interface{}) (Message, error) {
+	m := Message{
+		ID:     id,
+		Action: action,
+	}
+	if payload == nil {
+		return m, nil
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return m, err
+	}
+	m.Payload = raw
+	return m, nil
+}
+
+// DecodePayload unmarshals the payload in v, a message without payload leaves
+// v untouched.
+func (m *Message) DecodePayload(v interface{}) error {
+	if len(m.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(m.Payload, v)
 }
@@ -0,0 +1,194 @@
+package modelwebsocket
+
+import (
+	"encoding/json"
+
+	loimodel "github.com/phturb/bonjack-tools-backend-go/loi/model"
+)
+
+// Error codes of the websocket protocol, the game command errors use the codes
+// of the game manager.
+const (
+	CodeInvalidMessage     = "invalidMessage"
+	CodeUnknownAction      = "unknownAction"
+	CodeHelloRequired      = "helloRequired"
+	CodeUnsupportedVersion = "unsupportedVersion"
+	CodeRateLimited        = "rateLimited"
+	CodeMuted              = "muted"
+	CodeBusy               = "busy"
+)
+
+// ProtocolVersion is the version of the websocket protocol spoken by the
+// server, clients older than MinProtocolVersion are rejected on hello. The
+// minimum is raised with every breaking version, version 5 is the last one
+// that added required fields to the client payloads.
+const (
+	ProtocolVersion    = 8
+	MinProtocolVersion = 5
+)
+
+// Features a client can announce on hello, the server only uses the ones both
+// sides support.
+const (
+	// FeaturePatchState lets the client receive the state changes as
+	// patchState, the clients without it receive a full updateState instead.
+	FeaturePatchState = "patchState"
+)
+
+var Features = []string{
+	FeaturePatchState,
+}
+
+// HelloPayload is the first message sent by the client once connected.
+type HelloPayload struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Features        []string `json:"features"`
+}
+
+// WelcomePayload answers the client hello, features only lists the features
+// used for the connection.
+type WelcomePayload struct {
+	ProtocolVersion int       `json:"protocolVersion"`
+	Features        []string  `json:"features"`
+	ClientActions   []Action  `json:"clientActions"`
+	ServerActions   []Action  `json:"serverActions"`
+	Lobby           LobbyInfo `json:"lobby"`
+}
+
+type LobbyInfo struct {
+	GuildID     string             `json:"guildId"`
+	GuildName   string             `json:"guildName"`
+	ChannelID   string             `json:"channelId"`
+	ChannelName string             `json:"channelName"`
+	PlayerID    string             `json:"playerId"`
+	Role        loimodel.LobbyRole `json:"role"`
+}
+
+// ClientPayloads maps the client actions to the type of their payload, nil
+// when the action has none.
+var ClientPayloads = map[Action]interface{}{
+	UpdatePlayers:       UpdatePlayersPayload{},
+	Roll:                RollPayload{},
+	Cancel:              nil,
+	Reset:               nil,
+	RefreshDiscord:      nil,
+	TransferHost:        TransferHostPayload{},
+	UpdateSettings:      UpdateSettingsPayload{},
+	StartReadyCheck:     nil,
+	Ready:               nil,
+	PauseTimer:          nil,
+	ResumeTimer:         nil,
+	RequestSnapshot:     nil,
+	Hello:               HelloPayload{},
+	ListVoiceChannels:   nil,
+	SwitchVoiceChannel:  SwitchVoiceChannelPayload{},
+	AddRosterMember:     AddRosterMemberPayload{},
+	RemoveRosterMember:  RemoveRosterMemberPayload{},
+	AddGuest:            AddGuestPayload{},
+	RemoveGuest:         RemoveGuestPayload{},
+	MergeGuest:          MergeGuestPayload{},
+	AddToGuestPool:      GuestPoolPayload{},
+	RemoveFromGuestPool: GuestPoolPayload{},
+}
+
+// ServerPayloads maps the server actions to the type of their payload.
+var ServerPayloads = map[Action]interface{}{
+	UpdateState:   UpdateStatePayload{},
+	Ack:           AckPayload{},
+	Error:         ErrorPayload{},
+	PatchState:    PatchStatePayload{},
+	Welcome:       WelcomePayload{},
+	VoiceChannels: VoiceChannelsPayload{},
+}
+
+type UpdatePlayersPayload struct {
+	Players []loimodel.PlayerSlot `json:"players"`
+}
+
+type RollPayload struct {
+	RerollOnly bool `json:"rerollOnly"`
+}
+
+type TransferHostPayload struct {
+	PlayerID string `json:"playerId"`
+}
+
+type UpdateSettingsPayload struct {
+	Settings loimodel.GameSettings `json:"settings"`
+}
+
+type SwitchVoiceChannelPayload struct {
+	ChannelID string `json:"channelId"`
+}
+
+// AddRosterMemberPayload adds a player by hand when the players don't come
+// from discord, the id is made from the name when it is empty.
+type AddRosterMemberPayload struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type RemoveRosterMemberPayload struct {
+	ID string `json:"id"`
+}
+
+// AddGuestPayload adds a player without a discord account, a guest with the
+// same name is reused.
+type AddGuestPayload struct {
+	Name string `json:"name"`
+}
+
+type RemoveGuestPayload struct {
+	ID string `json:"id"`
+}
+
+// MergeGuestPayload merges a guest into the discord player once they joined
+// the server.
+type MergeGuestPayload struct {
+	GuestID  string `json:"guestId"`
+	PlayerID string `json:"playerId"`
+}
+
+// GuestPoolPayload adds or removes a champion from the pool of a guest.
+type GuestPoolPayload struct {
+	GuestID  string `json:"guestId"`
+	Champion string `json:"champion"`
+}
+
+// VoiceChannelsPayload answers listVoiceChannels with the voice channels of
+// the guild.
+type VoiceChannelsPayload struct {
+	Channels []loimodel.VoiceChannel `json:"channels"`
+}
+
+// UpdateStatePayload is a full snapshot of the game state, the following
+// patches apply on top of it in sequence order.
+type UpdateStatePayload struct {
+	Seq   uint64             `json:"seq"`
+	State loimodel.GameState `json:"state"`
+}
+
+// PatchOperation is a JSON Patch (RFC 6902) operation, only add, remove and
+// replace are emitted.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchStatePayload carries the changes since the state of sequence Seq-1, a
+// client that missed a sequence asks for a snapshot.
+type PatchStatePayload struct {
+	Seq        uint64           `json:"seq"`
+	Operations []PatchOperation `json:"operations"`
+}
+
+type AckPayload struct {
+	Action Action `json:"action"`
+}
+
+type ErrorPayload struct {
+	Action  Action `json:"action,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
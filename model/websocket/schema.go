@@ -0,0 +1,212 @@
+package modelwebsocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	loimodel "github.com/phturb/bonjack-tools-backend-go/loi/model"
+)
+
+// Schema is the subset of JSON Schema used to describe the protocol.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Const                string             `json:"const,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// SchemaDocument describes every message of the protocol, the definitions are
+// named after the Go types.
+type SchemaDocument struct {
+	Schema  string             `json:"$schema"`
+	ID      string             `json:"$id"`
+	Title   string             `json:"title"`
+	Version int                `json:"version"`
+	OneOf   []*Schema          `json:"oneOf"`
+	Defs    map[string]*Schema `json:"$defs"`
+}
+
+// schemaEnums lists the values of the string types that are enumerations.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(Action("")): actionNames(append(append([]Action{}, ClientActions...), ServerActions...)),
+	reflect.TypeOf(loimodel.Role("")): func() []string {
+		var l []string
+		for _, r := range loimodel.NewRoleSlice() {
+			l = append(l, string(r))
+		}
+		return l
+	}(),
+	reflect.TypeOf(loimodel.RollStrategy("")): {string(loimodel.RollStrategyRandom), string(loimodel.RollStrategyKeepRoles)},
+	reflect.TypeOf(loimodel.LobbyRole("")):    {string(loimodel.LobbyRoleHost), string(loimodel.LobbyRolePlayer), string(loimodel.LobbyRoleSpectator)},
+}
+
+func actionNames(actions []Action) []string {
+	l := make([]string, 0, len(actions))
+	for _, a := range actions {
+		l = append(l, string(a))
+	}
+	return l
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+type schemaBuilder struct {
+	defs  map[string]*Schema
+	types map[string]reflect.Type
+}
+
+// ProtocolSchema returns the schema of the client and server messages of the
+// current protocol version.
+func ProtocolSchema() *SchemaDocument {
+	b := &schemaBuilder{
+		defs:  map[string]*Schema{},
+		types: map[string]reflect.Type{},
+	}
+	client := b.messages("ClientMessage", ClientActions, ClientPayloads)
+	server := b.messages("ServerMessage", ServerActions, ServerPayloads)
+	return &SchemaDocument{
+		Schema:  "https://json-schema.org/draft/2020-12/schema",
+		ID:      fmt.Sprintf("loi-protocol-v%d", ProtocolVersion),
+		Title:   "LoI lobby protocol",
+		Version: ProtocolVersion,
+		OneOf:   []*Schema{client, server},
+		Defs:    b.defs,
+	}
+}
+
+// messages defines a message per action and their union.
+func (b *schemaBuilder) messages(name string, actions []Action, payloads map[Action]interface{}) *Schema {
+	union := &Schema{}
+	for _, a := range actions {
+		m := &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"id":     {Type: "string"},
+				"action": {Const: string(a)},
+			},
+			Required: []string{"action"},
+		}
+		if p := payloads[a]; p != nil {
+			m.Properties["payload"] = b.schema(reflect.TypeOf(p))
+			m.Required = append(m.Required, "payload")
+		}
+		n := exportedName(string(a)) + "Message"
+		b.define(n, nil, m)
+		union.OneOf = append(union.OneOf, &Schema{Ref: "#/$defs/" + n})
+	}
+	b.define(name, nil, union)
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+func (b *schemaBuilder) define(name string, t reflect.Type, s *Schema) {
+	if prev, ok := b.types[name]; ok && prev != t {
+		panic(fmt.Sprintf("schema definition '%s' is defined by %v and %v", name, prev, t))
+	}
+	b.types[name] = t
+	b.defs[name] = s
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	if values, ok := schemaEnums[t]; ok {
+		return b.named(t, func() *Schema { return &Schema{Type: "string", Enum: values} })
+	}
+	zero := 0
+	switch t.Kind() {
+	case reflect.Pointer:
+		return &Schema{AnyOf: []*Schema{b.schema(t.Elem()), {Type: "null"}}}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		return b.named(t, func() *Schema { return b.object(t) })
+	}
+	panic(fmt.Sprintf("no schema for type %v", t))
+}
+
+// named defines the named types once and refers to them.
+func (b *schemaBuilder) named(t reflect.Type, build func() *Schema) *Schema {
+	ref := &Schema{Ref: "#/$defs/" + t.Name()}
+	if prev, ok := b.types[t.Name()]; ok && prev == t {
+		return ref
+	}
+	// defined before building so recursive types refer to themselves
+	b.define(t.Name(), t, &Schema{})
+	b.defs[t.Name()] = build()
+	return ref
+}
+
+func (b *schemaBuilder) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	b.fields(t, s)
+	return s
+}
+
+// fields adds the json fields of the struct, embedded structs are flattened
+// like encoding/json does.
+func (b *schemaBuilder) fields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.fields(f.Type, s)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = b.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func exportedName(s string) string {
+	r := []rune(s)
+	if len(r) == 0 {
+		return s
+	}
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,59 @@
+package modelwebsocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSchemaVersion fails when the messages change without a new protocol
+// version, the schema of the current version is recorded in testdata and
+// replaces the previous one on every bump.
+func TestSchemaVersion(t *testing.T) {
+	path := fmt.Sprintf("testdata/schema.v%d.json", ProtocolVersion)
+	record := fmt.Sprintf("go run ./cmd/protocol -schema model/websocket/%s", path)
+	golden, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("no schema recorded for protocol version %d, record it with '%s'", ProtocolVersion, record)
+	}
+	assert.NoError(t, err)
+
+	raw, err := json.MarshalIndent(ProtocolSchema(), "", "  ")
+	assert.NoError(t, err)
+	if string(golden) != string(raw)+"\n" {
+		t.Fatalf("the protocol schema changed, bump ProtocolVersion and record the new schema with '%s'", record)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	doc := ProtocolSchema()
+
+	t.Run("Every action has a message", func(t *testing.T) {
+		for _, a := range append(append([]Action{}, ClientActions...), ServerActions...) {
+			m, ok := doc.Defs[exportedName(string(a))+"Message"]
+			if assert.True(t, ok, a) {
+				assert.Equal(t, string(a), m.Properties["action"].Const)
+			}
+		}
+	})
+
+	t.Run("Optional and nullable fields", func(t *testing.T) {
+		ap := doc.Defs["AvailablePlayer"]
+		assert.Equal(t, []string{"id"}, ap.Required)
+		assert.Equal(t, "null", ap.Properties["id"].AnyOf[1].Type)
+	})
+
+	t.Run("TypeScript definitions", func(t *testing.T) {
+		ts := TypeScript(doc)
+		assert.Contains(t, ts, fmt.Sprintf("export const PROTOCOL_VERSION = %d;", ProtocolVersion))
+		assert.Contains(t, ts, "export interface GameState {")
+		assert.Contains(t, ts, "  availablePlayers: Record<string, AvailablePlayer>;")
+		assert.Contains(t, ts, "  name?: string | null;")
+		assert.Contains(t, ts, `export type RollStrategy = "random" | "keepRoles";`)
+		assert.True(t, strings.Contains(ts, "export type ServerMessage = UpdateStateMessage | AckMessage"))
+	})
+}
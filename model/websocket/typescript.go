@@ -0,0 +1,98 @@
+package modelwebsocket
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TypeScript returns the TypeScript definitions of the schema, every
+// definition is exported under its schema name.
+func TypeScript(doc *SchemaDocument) string {
+	var sb strings.Builder
+	sb.WriteString("// Code generated by go run ./cmd/protocol; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&sb, "export const PROTOCOL_VERSION = %d;\n", doc.Version)
+
+	names := make([]string, 0, len(doc.Defs))
+	for n := range doc.Defs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		s := doc.Defs[n]
+		sb.WriteString("\n")
+		if s.Type == "object" && s.Properties != nil {
+			fmt.Fprintf(&sb, "export interface %s {\n", n)
+			writeProperties(&sb, s)
+			sb.WriteString("}\n")
+			continue
+		}
+		fmt.Fprintf(&sb, "export type %s = %s;\n", n, tsType(s))
+	}
+	return sb.String()
+}
+
+func writeProperties(sb *strings.Builder, s *Schema) {
+	props := make([]string, 0, len(s.Properties))
+	for p := range s.Properties {
+		props = append(props, p)
+	}
+	sort.Strings(props)
+	required := map[string]bool{}
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	for _, p := range props {
+		opt := "?"
+		if required[p] {
+			opt = ""
+		}
+		fmt.Fprintf(sb, "  %s%s: %s;\n", p, opt, tsType(s.Properties[p]))
+	}
+}
+
+func tsType(s *Schema) string {
+	switch {
+	case s.Ref != "":
+		return strings.TrimPrefix(s.Ref, "#/$defs/")
+	case s.Const != "":
+		return strconv.Quote(s.Const)
+	case len(s.Enum) > 0:
+		values := make([]string, 0, len(s.Enum))
+		for _, v := range s.Enum {
+			values = append(values, strconv.Quote(v))
+		}
+		return strings.Join(values, " | ")
+	case len(s.AnyOf) > 0:
+		return tsUnion(s.AnyOf)
+	case len(s.OneOf) > 0:
+		return tsUnion(s.OneOf)
+	}
+	switch s.Type {
+	case "string", "boolean", "number", "null":
+		return s.Type
+	case "integer":
+		return "number"
+	case "array":
+		t := tsType(s.Items)
+		if strings.Contains(t, " | ") {
+			t = "(" + t + ")"
+		}
+		return t + "[]"
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "Record<string, " + tsType(s.AdditionalProperties) + ">"
+		}
+		return "Record<string, unknown>"
+	}
+	return "unknown"
+}
+
+func tsUnion(l []*Schema) string {
+	types := make([]string, 0, len(l))
+	for _, s := range l {
+		types = append(types, tsType(s))
+	}
+	return strings.Join(types, " | ")
+}
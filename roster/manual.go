@@ -0,0 +1,80 @@
+package roster
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Manual is a roster edited by hand from the web client, it lets groups play
+// without a discord bot.
+type Manual struct {
+	mu      sync.Mutex
+	members map[string]Member
+	events  Events
+}
+
+var _ Editable = (*Manual)(nil)
+
+func NewManual() *Manual {
+	return &Manual{
+		members: map[string]Member{},
+	}
+}
+
+// Subscribe implements Provider.
+func (r *Manual) Subscribe(events Events) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = events
+}
+
+// Snapshot implements Provider.
+func (r *Manual) Snapshot(ctx context.Context) ([]Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sortedMembers(r.members), nil
+}
+
+// Add implements Editable.
+func (r *Manual) Add(ctx context.Context, m Member) error {
+	r.mu.Lock()
+	if _, ok := r.members[m.ID]; ok {
+		r.mu.Unlock()
+		return ErrMemberExists
+	}
+	r.members[m.ID] = m
+	events := r.events
+	r.mu.Unlock()
+	if events != nil {
+		events.MemberJoined(m)
+	}
+	return nil
+}
+
+// Remove implements Editable.
+func (r *Manual) Remove(ctx context.Context, id string) error {
+	r.mu.Lock()
+	if _, ok := r.members[id]; !ok {
+		r.mu.Unlock()
+		return ErrMemberNotFound
+	}
+	delete(r.members, id)
+	events := r.events
+	r.mu.Unlock()
+	if events != nil {
+		events.MemberLeft(id)
+	}
+	return nil
+}
+
+func sortedMembers(members map[string]Member) []Member {
+	ms := make([]Member, 0, len(members))
+	for _, m := range members {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].ID < ms[j].ID
+	})
+	return ms
+}
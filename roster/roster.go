@@ -0,0 +1,54 @@
+package roster
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrMemberExists is returned when a member is added twice to a roster.
+var ErrMemberExists = errors.New("member is already in the roster")
+
+// ErrMemberNotFound is returned when a member is not in the roster.
+var ErrMemberNotFound = errors.New("member is not in the roster")
+
+// Member is someone who can be slotted in the game.
+type Member struct {
+	ID   string
+	Name string
+	// Avatar is the discord avatar hash of the member, if any.
+	Avatar *string
+}
+
+// Events receives the changes of a roster, the calls can be made from any
+// goroutine.
+type Events interface {
+	// RosterSet replaces every member of the roster.
+	RosterSet(members []Member)
+	MemberJoined(m Member)
+	MemberUpdated(m Member)
+	MemberLeft(id string)
+}
+
+// Provider tells who is in the lobby.
+type Provider interface {
+	// Subscribe sends the changes of the roster to events, it is called once
+	// before the provider starts.
+	Subscribe(events Events)
+	// Snapshot returns the members currently in the roster.
+	Snapshot(ctx context.Context) ([]Member, error)
+}
+
+// Editable is implemented by the providers whose members are added by hand.
+type Editable interface {
+	Provider
+	Add(ctx context.Context, m Member) error
+	Remove(ctx context.Context, id string) error
+}
+
+// Lobby is implemented by the providers tracking where the members are, the
+// game tells them which places count as the lobby.
+type Lobby interface {
+	Provider
+	// SetLobby replaces the places of the lobby, it does not send events.
+	SetLobby(ids []string)
+}
@@ -0,0 +1,161 @@
+// Package rostertest provides an in-memory roster for the tests.
+package rostertest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/phturb/bonjack-tools-backend-go/roster"
+)
+
+// Fake is an in-memory roster for the tests, the changes are sent to the
+// events before the methods return.
+type Fake struct {
+	mu      sync.Mutex
+	members map[string]roster.Member
+	lobby   []string
+	events  roster.Events
+	// Err makes the snapshots fail when set.
+	Err error
+}
+
+var _ roster.Editable = (*Fake)(nil)
+var _ roster.Lobby = (*Fake)(nil)
+
+func NewFake(members ...roster.Member) *Fake {
+	f := &Fake{
+		members: map[string]roster.Member{},
+	}
+	for _, m := range members {
+		f.members[m.ID] = m
+	}
+	return f
+}
+
+// Subscribe implements roster.Provider.
+func (f *Fake) Subscribe(events roster.Events) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = events
+}
+
+// Snapshot implements roster.Provider.
+func (f *Fake) Snapshot(ctx context.Context) ([]roster.Member, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return sortedMembers(f.members), nil
+}
+
+// SetLobby implements roster.Lobby.
+func (f *Fake) SetLobby(ids []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lobby = append([]string{}, ids...)
+}
+
+// Lobby returns the places of the lobby given by the game.
+func (f *Fake) Lobby() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.lobby...)
+}
+
+// Add implements roster.Editable.
+func (f *Fake) Add(ctx context.Context, m roster.Member) error {
+	f.mu.Lock()
+	if _, ok := f.members[m.ID]; ok {
+		f.mu.Unlock()
+		return roster.ErrMemberExists
+	}
+	f.mu.Unlock()
+	f.Join(m)
+	return nil
+}
+
+// Remove implements roster.Editable.
+func (f *Fake) Remove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	if _, ok := f.members[id]; !ok {
+		f.mu.Unlock()
+		return roster.ErrMemberNotFound
+	}
+	f.mu.Unlock()
+	f.Leave(id)
+	return nil
+}
+
+// Put replaces every member of the roster without sending events, like a
+// gateway event that was missed.
+func (f *Fake) Put(members ...roster.Member) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.members = map[string]roster.Member{}
+	for _, m := range members {
+		f.members[m.ID] = m
+	}
+}
+
+// Set replaces every member of the roster.
+func (f *Fake) Set(members ...roster.Member) {
+	f.mu.Lock()
+	f.members = map[string]roster.Member{}
+	for _, m := range members {
+		f.members[m.ID] = m
+	}
+	ms := sortedMembers(f.members)
+	events := f.events
+	f.mu.Unlock()
+	if events != nil {
+		events.RosterSet(ms)
+	}
+}
+
+// Join adds the member to the roster.
+func (f *Fake) Join(m roster.Member) {
+	f.mu.Lock()
+	f.members[m.ID] = m
+	events := f.events
+	f.mu.Unlock()
+	if events != nil {
+		events.MemberJoined(m)
+	}
+}
+
+// Update changes a member, the member does not have to be in the roster.
+func (f *Fake) Update(m roster.Member) {
+	f.mu.Lock()
+	if _, ok := f.members[m.ID]; ok {
+		f.members[m.ID] = m
+	}
+	events := f.events
+	f.mu.Unlock()
+	if events != nil {
+		events.MemberUpdated(m)
+	}
+}
+
+// Leave removes the member from the roster.
+func (f *Fake) Leave(id string) {
+	f.mu.Lock()
+	delete(f.members, id)
+	events := f.events
+	f.mu.Unlock()
+	if events != nil {
+		events.MemberLeft(id)
+	}
+}
+
+func sortedMembers(members map[string]roster.Member) []roster.Member {
+	ms := make([]roster.Member, 0, len(members))
+	for _, m := range members {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].ID < ms[j].ID
+	})
+	return ms
+}
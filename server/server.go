@@ -14,28 +14,62 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/auth"
 	"github.com/phturb/bonjack-tools-backend-go/internal"
 	"github.com/phturb/bonjack-tools-backend-go/loi"
 	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
 )
 
+const (
+	// helloTimeout is how long a new websocket connection has to send its
+	// hello.
+	helloTimeout = 10 * time.Second
+	// maxMessageSize is the size limit of a message received on /ws.
+	maxMessageSize = 64 * 1024
+)
+
 type server struct {
-	srv *http.Server
-	up  *websocket.Upgrader
-	gm  loi.GameManager
+	srv  *http.Server
+	up   *websocket.Upgrader
+	gm   loi.GameManager
+	auth auth.Authenticator
 }
 
 func NewServer(gm loi.GameManager) (*server, error) {
+	a, err := auth.NewAuthenticator(auth.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
 	return &server{
 		up: &websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Accepting all requests
-			},
+			CheckOrigin: checkOrigin(internal.Config().Server.AllowedOrigins),
 		},
-		gm: gm,
+		gm:   gm,
+		auth: a,
 	}, nil
 }
 
+// checkOrigin only accepts the configured origins, when no origin is
+// configured the websocket upgrader falls back to a same origin check.
+func checkOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range allowedOrigins {
+			if o == "*" || o == origin {
+				return true
+			}
+		}
+		slog.Warn(fmt.Sprintf("[ws] - rejecting websocket connection from origin '%s'", origin))
+		return false
+	}
+}
+
 func (s *server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := s.up.Upgrade(w, r, nil)
 	if err != nil {
@@ -43,7 +77,26 @@ func (s *server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
-	s.gm.HandleWebsocketConnection(conn, r)
+	// bigger messages close the connection with a message too big frame
+	conn.SetReadLimit(maxMessageSize)
+
+	// the client has to introduce itself before anything else, old clients
+	// that don't are rejected with a close frame.
+	reject := func(reason string) {
+		slog.Warn(fmt.Sprintf("[ws] - rejecting websocket connection : %s", reason))
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "handshake failed"), time.Now().Add(time.Second))
+	}
+	var hello *modelwebsocket.Message
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	if err := conn.ReadJSON(&hello); err != nil {
+		reject(fmt.Sprintf("no hello received : %v", err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if err := s.gm.HandleWebsocketConnection(conn, r, hello); err != nil {
+		reject(err.Error())
+		return
+	}
 
 	for {
 		mt, m, err := conn.ReadMessage()
@@ -54,6 +107,7 @@ func (s *server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 		var wm modelwebsocket.Message
 		if err := json.Unmarshal(m, &wm); err != nil {
 			slog.Warn(fmt.Sprintf("[ws] - unable to unmarshal the received message : %v", err))
+			s.gm.SendWebsocketError(conn, nil, modelwebsocket.CodeInvalidMessage, err.Error())
 			continue
 		}
 		if s.gm.HandleWebsocketMessage(&wm, conn, r) {
@@ -61,7 +115,15 @@ func (s *server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		slog.Warn("[ws] - no handlers processed the websocket message")
+		s.gm.SendWebsocketError(conn, &wm, modelwebsocket.CodeUnknownAction, fmt.Sprintf("action '%s' is not handled", wm.Action))
 	}
+	s.gm.HandleWebsocketDisconnect(conn)
+}
+
+// handleSchema serves the JSON schema of the lobby protocol.
+func (s *server) handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(modelwebsocket.ProtocolSchema())
 }
 
 func spaHandler(staticPath string, indexPath string) func(http.ResponseWriter, *http.Request) {
@@ -96,18 +158,47 @@ func (s *server) GetHTTPServer() (*http.Server, error) {
 	return s.srv, nil
 }
 
-func (s *server) Start(ctx context.Context) chan error {
+// handler returns the routes of the server, the session of the request is
+// attached when there is one.
+func (s *server) handler() http.Handler {
 	router := mux.NewRouter()
-	serverAddr := "0.0.0.0:" + internal.Config().Server.Port
-	slog.Info("[server] - starting server on port " + serverAddr)
-	slog.Info("[server] - handling websocket on path : '/ws'")
+	router.Use(s.auth.Middleware)
 	router.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
 	})
+	router.HandleFunc("/api/auth/login", s.auth.Login).Methods(http.MethodGet)
+	router.HandleFunc("/api/auth/callback", s.auth.Callback).Methods(http.MethodGet)
+	router.HandleFunc("/api/auth/logout", s.auth.Logout).Methods(http.MethodPost)
+	router.HandleFunc("/api/auth/admin", s.auth.AdminLogin).Methods(http.MethodPost)
+	router.HandleFunc("/api/schema", s.handleSchema).Methods(http.MethodGet)
+	// the read endpoints are public for the displays that can't log in
+	router.HandleFunc("/api/lobbies/{id}/state", func(w http.ResponseWriter, r *http.Request) {
+		s.gm.HandleState(w, r, mux.Vars(r)["id"])
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/api/lobbies/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		s.gm.HandleEvents(w, r, mux.Vars(r)["id"])
+	}).Methods(http.MethodGet)
+	// every other api route needs a session
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(s.auth.Require)
+	api.HandleFunc("/auth/me", s.auth.Me).Methods(http.MethodGet)
+	// the connections without session are the spectators, the game manager
+	// refuses their commands
 	router.HandleFunc("/ws", s.handleWebsocket)
 	router.PathPrefix("/").HandlerFunc(spaHandler("static", "index.html"))
+	allowedOrigins := internal.Config().Server.AllowedOrigins
+	if len(allowedOrigins) == 0 {
+		allowedOrigins = []string{"*"}
+	}
+	return handlers.CORS(handlers.AllowedOrigins(allowedOrigins))(router)
+}
+
+func (s *server) Start(ctx context.Context) chan error {
+	serverAddr := "0.0.0.0:" + internal.Config().Server.Port
+	slog.Info("[server] - starting server on port " + serverAddr)
+	slog.Info("[server] - handling websocket on path : '/ws'")
 	srv := &http.Server{
-		Handler: handlers.CORS(handlers.AllowedOrigins([]string{"*"}))(router),
+		Handler: s.handler(),
 		Addr:    serverAddr,
 		// Good practice: enforce timeouts for servers you create!
 		WriteTimeout: 15 * time.Second,
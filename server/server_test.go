@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/auth"
+	"github.com/phturb/bonjack-tools-backend-go/internal"
+	"github.com/phturb/bonjack-tools-backend-go/loi"
+	loimodel "github.com/phturb/bonjack-tools-backend-go/loi/model"
+	sharedmodel "github.com/phturb/bonjack-tools-backend-go/model"
+	modelwebsocket "github.com/phturb/bonjack-tools-backend-go/model/websocket"
+	"github.com/phturb/bonjack-tools-backend-go/roster"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type testDependencies struct {
+	db *gorm.DB
+}
+
+func (d *testDependencies) Database(ctx context.Context) *gorm.DB {
+	return d.db
+}
+
+func (d *testDependencies) Cron() *cron.Cron {
+	return cron.New()
+}
+
+// setupTest starts the server without discord bot, the players are added by
+// hand.
+func setupTest(t *testing.T) (*httptest.Server, loi.GameManager) {
+	internal.LoadConfig("../.env.test")
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s-%d?mode=memory&cache=shared", t.Name(), time.Now().UnixNano())), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(
+		&sharedmodel.Game{},
+		&sharedmodel.Player{},
+		&sharedmodel.GamePlayer{},
+		&sharedmodel.GamePlayerRoll{},
+		&sharedmodel.GamePause{},
+		&sharedmodel.Champion{},
+		&sharedmodel.PlayerChampion{},
+		&sharedmodel.WeeklyChampion{},
+		&sharedmodel.LaneRole{},
+		&sharedmodel.LeagueVersion{},
+		&sharedmodel.LobbyChannel{},
+		&sharedmodel.LaneAssignment{},
+		&sharedmodel.TeamMove{},
+	))
+	db.Create(&sharedmodel.LeagueVersion{Version: "14.1.1"})
+	db.Create(&sharedmodel.Champion{ID: "1", Name: "Ashe", Img: "Ashe.png"})
+	db.Create(&sharedmodel.Champion{ID: "2", Name: "Garen", Img: "Garen.png"})
+
+	gm := loi.NewGameManager(&testDependencies{db: db}, nil, roster.NewManual())
+	s, err := NewServer(gm)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(s.handler())
+	t.Cleanup(srv.Close)
+	return srv, gm
+}
+
+// dial connects to the lobby with the headers and says hello, it returns the
+// welcome.
+func dial(t *testing.T, srv *httptest.Server, header http.Header) (*websocket.Conn, modelwebsocket.WelcomePayload) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	hello, err := modelwebsocket.NewMessage("hello", modelwebsocket.Hello, modelwebsocket.HelloPayload{
+		ProtocolVersion: modelwebsocket.ProtocolVersion,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, conn.WriteJSON(hello))
+	m := read(t, conn, modelwebsocket.Welcome)
+	var p modelwebsocket.WelcomePayload
+	assert.NoError(t, m.DecodePayload(&p))
+	return conn, p
+}
+
+// read returns the next message with the given action, the other ones are
+// skipped.
+func read(t *testing.T, conn *websocket.Conn, action modelwebsocket.Action) modelwebsocket.Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var m modelwebsocket.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Action == action {
+			return m
+		}
+	}
+}
+
+// reply returns the answer to the command with the id, the state updates are
+// skipped.
+func reply(t *testing.T, conn *websocket.Conn, id string) modelwebsocket.Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var m modelwebsocket.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID == id {
+			return m
+		}
+	}
+}
+
+// send sends the command and makes sure it is acknowledged.
+func send(t *testing.T, conn *websocket.Conn, id string, action modelwebsocket.Action, payload interface{}) {
+	m, err := modelwebsocket.NewMessage(id, action, payload)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.WriteJSON(m))
+	assert.Equal(t, modelwebsocket.Ack, reply(t, conn, id).Action)
+}
+
+func TestManualLobby(t *testing.T) {
+	srv, _ := setupTest(t)
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	u, _ := url.Parse(srv.URL)
+
+	t.Run("The admin logs in with the configured token", func(t *testing.T) {
+		res, err := client.Post(srv.URL+"/api/auth/admin", "application/json", strings.NewReader(`{"token":"forged"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		res, err = client.Post(srv.URL+"/api/auth/admin", "application/json", strings.NewReader(`{"token":"admin-token"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	})
+
+	header := http.Header{}
+	for _, c := range jar.Cookies(u) {
+		header.Add("Cookie", c.String())
+	}
+	conn, welcome := dial(t, srv, header)
+	assert.Equal(t, auth.AdminPlayerID, welcome.Lobby.PlayerID)
+	assert.Equal(t, loimodel.LobbyRoleHost, welcome.Lobby.Role)
+
+	t.Run("The admin adds the players and rolls", func(t *testing.T) {
+		send(t, conn, "add-1", modelwebsocket.AddRosterMember, modelwebsocket.AddRosterMemberPayload{Name: "Alice"})
+		send(t, conn, "add-2", modelwebsocket.AddRosterMember, modelwebsocket.AddRosterMemberPayload{Name: "Bob"})
+		send(t, conn, "roll-1", modelwebsocket.Roll, modelwebsocket.RollPayload{})
+	})
+
+	t.Run("The state is read with the configured lobby id", func(t *testing.T) {
+		res, err := http.Get(srv.URL + "/api/lobbies/test-lobby/state")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var p modelwebsocket.UpdateStatePayload
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+		assert.True(t, p.State.GameInProgress)
+		names := []string{}
+		for _, gp := range p.State.Players {
+			if gp.Player.ID == "" {
+				continue
+			}
+			names = append(names, *gp.Player.Name)
+			assert.NotNil(t, gp.Champion)
+		}
+		assert.ElementsMatch(t, []string{"Alice", "Bob"}, names)
+
+		res, err = http.Get(srv.URL + "/api/lobbies/test-guild/state")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func TestSpectators(t *testing.T) {
+	srv, _ := setupTest(t)
+	conn, welcome := dial(t, srv, nil)
+	assert.Equal(t, "", welcome.Lobby.PlayerID)
+	assert.Equal(t, loimodel.LobbyRoleSpectator, welcome.Lobby.Role)
+
+	t.Run("Spectators receive the state", func(t *testing.T) {
+		m := read(t, conn, modelwebsocket.UpdateState)
+		var s modelwebsocket.UpdateStatePayload
+		assert.NoError(t, m.DecodePayload(&s))
+		assert.False(t, s.State.GameInProgress)
+	})
+
+	t.Run("Spectators can not send commands", func(t *testing.T) {
+		assert.NoError(t, conn.WriteJSON(modelwebsocket.Message{ID: "roll-1", Action: modelwebsocket.Roll}))
+		m := read(t, conn, modelwebsocket.Error)
+		assert.Equal(t, "roll-1", m.ID)
+		var p modelwebsocket.ErrorPayload
+		assert.NoError(t, m.DecodePayload(&p))
+		assert.Equal(t, string(loi.CodeForbidden), p.Code)
+	})
+}